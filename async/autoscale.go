@@ -0,0 +1,236 @@
+package async
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// AutoscaleConfig configures the autoscaling mode of a WorkerPool (see WorkerPool.Autoscale).
+//
+// At every Interval the pool is sampled for its size, the number of tasks queued in the task channel, and the ratio of
+// busy workers to workers:
+//   - If the pool is smaller than MinWorkers (or larger than MaxWorkers), it is resized to the bound immediately.
+//   - If more than ScaleUpQueueLength tasks are queued and the busy ratio is at least ScaleUpBusyRatio, ScaleUpStep
+//     workers are added (up to MaxWorkers).
+//   - If no tasks are queued and the busy ratio is at most ScaleDownBusyRatio, ScaleDownStep workers are removed (down
+//     to MinWorkers).
+//
+// A scale-up is not performed until ScaleUpCooldown has elapsed since the last resize, and likewise for scale-downs
+// and ScaleDownCooldown.
+type AutoscaleConfig struct {
+	MinWorkers int
+	MaxWorkers int           `config:"required"`
+	Interval   time.Duration `config:"required"`
+
+	ScaleUpQueueLength int     // scale up when more than this many tasks are queued (default 0: any queued task)
+	ScaleUpBusyRatio   float64 // ...and at least this ratio of workers are busy (default 0: no requirement)
+	ScaleDownBusyRatio float64 // scale down when nothing is queued and at most this ratio of workers are busy
+
+	ScaleUpStep   int // workers added per scale-up (default 1)
+	ScaleDownStep int // workers removed per scale-down (default 1)
+
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
+
+func validateAutoscaleConfig(cfg *AutoscaleConfig) error {
+
+	if err := config.ValidateConstraints(cfg); err != nil {
+		return err
+	}
+
+	if cfg.MinWorkers < 0 {
+		return fmt.Errorf("MinWorkers cannot be negative (%d)", cfg.MinWorkers)
+	}
+
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		return fmt.Errorf("MaxWorkers (%d) cannot be less than MinWorkers (%d)", cfg.MaxWorkers, cfg.MinWorkers)
+	}
+
+	if cfg.Interval < 0 || cfg.ScaleUpCooldown < 0 || cfg.ScaleDownCooldown < 0 {
+		return fmt.Errorf("durations cannot be negative")
+	}
+
+	if cfg.ScaleUpQueueLength < 0 || cfg.ScaleUpStep < 0 || cfg.ScaleDownStep < 0 {
+		return fmt.Errorf("ScaleUpQueueLength, ScaleUpStep and ScaleDownStep cannot be negative")
+	}
+
+	if cfg.ScaleUpBusyRatio < 0 || cfg.ScaleUpBusyRatio > 1 || cfg.ScaleDownBusyRatio < 0 || cfg.ScaleDownBusyRatio > 1 {
+		return fmt.Errorf("busy ratios must be within [0, 1]")
+	}
+
+	return nil
+}
+
+// autoscaler is the control loop behind WorkerPool.Autoscale.
+type autoscaler struct {
+	config    AutoscaleConfig
+	lastScale time.Time
+	stop      chan struct{}
+}
+
+func newAutoscaler(cfg AutoscaleConfig) *autoscaler {
+
+	if cfg.ScaleUpStep == 0 {
+		cfg.ScaleUpStep = 1
+	}
+
+	if cfg.ScaleDownStep == 0 {
+		cfg.ScaleDownStep = 1
+	}
+
+	return &autoscaler{
+		config: cfg,
+		stop:   make(chan struct{})}
+}
+
+// target returns the desired pool size, given a sample of the pool taken at 'now'.
+func (a *autoscaler) target(now time.Time, size int, queued int, busy int) int {
+
+	cfg := &a.config
+
+	if size < cfg.MinWorkers {
+		return cfg.MinWorkers
+	}
+
+	if size > cfg.MaxWorkers {
+		return cfg.MaxWorkers
+	}
+
+	busyRatio := 1.0 // an empty pool is considered saturated
+	if size > 0 {
+		busyRatio = float64(busy) / float64(size)
+	}
+
+	sinceLastScale := now.Sub(a.lastScale)
+
+	if queued > cfg.ScaleUpQueueLength && busyRatio >= cfg.ScaleUpBusyRatio && size < cfg.MaxWorkers {
+		if sinceLastScale >= cfg.ScaleUpCooldown {
+			return minInt(size+cfg.ScaleUpStep, cfg.MaxWorkers)
+		}
+	} else if queued == 0 && busyRatio <= cfg.ScaleDownBusyRatio && size > cfg.MinWorkers {
+		if sinceLastScale >= cfg.ScaleDownCooldown {
+			return maxInt(size-cfg.ScaleDownStep, cfg.MinWorkers)
+		}
+	}
+
+	return size
+}
+
+// run samples and resizes the pool at every interval, until the autoscaler is stopped or the pool is abandoned.
+func (a *autoscaler) run(p *WorkerPool) {
+
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	a.sample(p, time.Now())
+
+	for {
+		select {
+		case now := <-ticker.C:
+			a.sample(p, now)
+
+		case <-a.stop:
+			return
+
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *autoscaler) sample(p *WorkerPool, now time.Time) {
+
+	size := p.Size()
+
+	var target int
+	if p.IsPaused() {
+		// the queue is expected to back up while paused; just keep the pool within bounds
+		target = maxInt(minInt(size, a.config.MaxWorkers), a.config.MinWorkers)
+	} else {
+		target = a.target(now, size, len(p.tasks), int(atomic.LoadInt32(&p.busy)))
+	}
+
+	var err error
+	switch {
+	case target > size:
+		err = p.Add(target - size)
+	case target < size:
+		err = p.Remove(size - target)
+	default:
+		return
+	}
+
+	// Errors are expected only if the pool was abandoned (or resized by the caller) since the sample was taken; the
+	// next sample will correct for the latter.
+	if err == nil {
+		a.lastScale = now
+	}
+}
+
+// Autoscale puts the pool into autoscaling mode, in which a background goroutine periodically adds and removes workers
+// based on the depth of the task channel and the ratio of busy workers (see AutoscaleConfig). The pool is immediately
+// resized to within [MinWorkers, MaxWorkers].
+//
+// Manual calls to Add and Remove remain possible while autoscaling, but will be corrected towards the configured
+// bounds. Autoscaling stops when StopAutoscale, Shutdown or Abandon is called, and is suspended (other than keeping the
+// pool within bounds) while the pool is paused.
+//
+// Autoscale returns an error if the config is invalid, if the pool is already autoscaling, or if the pool has been
+// abandoned or shut down.
+func (p *WorkerPool) Autoscale(cfg AutoscaleConfig) error {
+
+	if err := validateAutoscaleConfig(&cfg); err != nil {
+		return fmt.Errorf("invalid autoscale config: %v", err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.isAbandoned {
+		return fmt.Errorf("tried to autoscale after pool has been abandoned")
+	}
+
+	if p.isShutdown {
+		return fmt.Errorf("tried to autoscale after pool has been shut down")
+	}
+
+	if p.autoscaler != nil {
+		return fmt.Errorf("pool is already autoscaling")
+	}
+
+	p.autoscaler = newAutoscaler(cfg)
+	go p.autoscaler.run(p)
+
+	return nil
+}
+
+// StopAutoscale takes the pool out of autoscaling mode, leaving the pool at its current size. StopAutoscale is a
+// no-op if the pool is not autoscaling.
+func (p *WorkerPool) StopAutoscale() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.autoscaler != nil {
+		close(p.autoscaler.stop)
+		p.autoscaler = nil
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
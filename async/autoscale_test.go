@@ -0,0 +1,77 @@
+package async_test
+
+import (
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Autoscale", func() {
+
+	var tasks chan interface{}
+	var release chan struct{}
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		tasks = make(chan interface{}, 16)
+		release = make(chan struct{})
+
+		var err error
+		pool, err = NewWorkerPool(tasks, func(interface{}) {
+			<-release
+		})
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		close(release)
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("returns an error on an invalid config", func() {
+		Expect(pool.Autoscale(AutoscaleConfig{})).To(HaveOccurred())
+	})
+
+	It("returns an error if the pool is already autoscaling", func() {
+		cfg := AutoscaleConfig{MaxWorkers: 1, Interval: time.Millisecond}
+		Expect(pool.Autoscale(cfg)).To(Succeed())
+		Expect(pool.Autoscale(cfg)).To(HaveOccurred())
+
+		pool.StopAutoscale()
+		Expect(pool.Autoscale(cfg)).To(Succeed())
+	})
+
+	It("returns an error if called on an abandoned pool", func() {
+		pool.Abandon()
+		Expect(pool.Autoscale(AutoscaleConfig{MaxWorkers: 1, Interval: time.Millisecond})).To(HaveOccurred())
+	})
+
+	It("grows the pool to MinWorkers, then towards MaxWorkers under load, then shrinks when idle", func() {
+		Expect(pool.Autoscale(AutoscaleConfig{
+			MinWorkers: 1,
+			MaxWorkers: 3,
+			Interval:   time.Millisecond})).To(Succeed())
+
+		Eventually(pool.Size).Should(Equal(1))
+
+		for i := 0; i < 8; i++ {
+			tasks <- i
+		}
+
+		Eventually(pool.Size).Should(Equal(3))
+
+		// let the backlog drain
+		go func() {
+			for i := 0; i < 8; i++ {
+				release <- struct{}{}
+			}
+		}()
+
+		Eventually(pool.Size).Should(Equal(1))
+	})
+})
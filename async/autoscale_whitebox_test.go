@@ -0,0 +1,80 @@
+package async
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("autoscaler", func() {
+
+	var a *autoscaler
+	var now time.Time
+
+	BeforeEach(func() {
+		a = newAutoscaler(AutoscaleConfig{
+			MinWorkers:         1,
+			MaxWorkers:         4,
+			Interval:           time.Second,
+			ScaleUpQueueLength: 2,
+			ScaleUpBusyRatio:   0.5,
+			ScaleDownBusyRatio: 0.25,
+			ScaleUpStep:        2,
+			ScaleUpCooldown:    time.Minute,
+			ScaleDownCooldown:  time.Minute})
+
+		now = time.Now()
+	})
+
+	Describe("target", func() {
+		It("resizes to within the bounds regardless of cooldown", func() {
+			a.lastScale = now
+			Expect(a.target(now, 0, 0, 0)).To(Equal(1))
+			Expect(a.target(now, 6, 0, 0)).To(Equal(4))
+		})
+
+		It("scales up by the step when the queue and busy thresholds are exceeded", func() {
+			Expect(a.target(now, 1, 3, 1)).To(Equal(3))
+			Expect(a.target(now, 3, 3, 3)).To(Equal(4)) // clamped to MaxWorkers
+		})
+
+		It("does not scale up if the queue threshold is not exceeded", func() {
+			Expect(a.target(now, 2, 2, 2)).To(Equal(2))
+		})
+
+		It("does not scale up if the busy threshold is not met", func() {
+			Expect(a.target(now, 4, 10, 1)).To(Equal(4))
+			Expect(a.target(now, 3, 10, 1)).To(Equal(3))
+		})
+
+		It("scales down when nothing is queued and few workers are busy", func() {
+			Expect(a.target(now, 4, 0, 1)).To(Equal(3))
+			Expect(a.target(now, 1, 0, 0)).To(Equal(1)) // clamped to MinWorkers
+		})
+
+		It("respects the cooldowns", func() {
+			a.lastScale = now.Add(-30 * time.Second)
+			Expect(a.target(now, 1, 3, 1)).To(Equal(1))
+			Expect(a.target(now, 4, 0, 0)).To(Equal(4))
+
+			a.lastScale = now.Add(-2 * time.Minute)
+			Expect(a.target(now, 1, 3, 1)).To(Equal(3))
+			Expect(a.target(now, 4, 0, 0)).To(Equal(3))
+		})
+	})
+
+	Describe("validateAutoscaleConfig", func() {
+		It("requires MaxWorkers and Interval", func() {
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{Interval: time.Second})).To(HaveOccurred())
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{MaxWorkers: 1})).To(HaveOccurred())
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{MaxWorkers: 1, Interval: time.Second})).NotTo(HaveOccurred())
+		})
+
+		It("rejects inconsistent bounds and out-of-range ratios", func() {
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{MinWorkers: 2, MaxWorkers: 1, Interval: time.Second})).To(HaveOccurred())
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{MinWorkers: -1, MaxWorkers: 1, Interval: time.Second})).To(HaveOccurred())
+			Expect(validateAutoscaleConfig(&AutoscaleConfig{MaxWorkers: 1, Interval: time.Second, ScaleUpBusyRatio: 1.5})).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,176 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// BatchHandler handles a batch of tasks (see BatchWorker).
+type BatchHandler func(ctx context.Context, batch []interface{}) error
+
+// BatchConfig configures the batching of a BatchWorker.
+type BatchConfig struct {
+	MaxSize  int           `config:"required"` // a batch is flushed once it holds this many tasks
+	MaxDelay time.Duration `config:"required"` // a batch is flushed once its oldest task has waited this long
+}
+
+// BatchWorker is a worker that collects tasks into batches, and handles a batch at a time: a batch is flushed to the
+// handler once it reaches the configured maximum size, or once the oldest task in the batch has waited for the
+// configured maximum delay, whichever comes first.
+//
+// Closing the task channel drains the worker: the remaining tasks (including a partial batch) are flushed, then the
+// worker stops, so that no tasks are lost.
+//
+// The batch (a []interface{}) is the unit of work as far as the Options are concerned: e.g. a failed batch is passed
+// to OnError, and a RetryPolicy retries the whole batch.
+type BatchWorker struct {
+	tasks       chan interface{}
+	config      BatchConfig
+	batches     chan interface{} // flushed batches, consumed by the worker
+	worker      *Worker
+	waitGroup   *sync.WaitGroup
+	abandon     chan struct{}
+	abandonOnce sync.Once
+}
+
+// NewBatchWorker creates, starts, and returns a new BatchWorker, which accepts tasks from the 'tasks' channel and
+// passes them in batches to handleBatch. See NewWorkerWithContext for the context, WaitGroup, and options.
+// NewBatchWorker will return an error if 'ctx', 'tasks' or 'handleBatch' are nil, or if the config is invalid.
+func NewBatchWorker(ctx context.Context, tasks chan interface{}, cfg BatchConfig, handleBatch BatchHandler, waitGroup *sync.WaitGroup, opts *Options) (*BatchWorker, error) {
+
+	if tasks == nil {
+		return nil, fmt.Errorf("tasks channel cannot be nil")
+	}
+
+	if handleBatch == nil {
+		return nil, fmt.Errorf("handleBatch func cannot be nil")
+	}
+
+	if err := config.ValidateConstraints(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid batch config: %v", err)
+	}
+
+	if cfg.MaxSize < 1 || cfg.MaxDelay < 0 {
+		return nil, fmt.Errorf("invalid batch config: MaxSize and MaxDelay must be positive")
+	}
+
+	if waitGroup == nil {
+		waitGroup = &sync.WaitGroup{}
+	}
+
+	b := &BatchWorker{
+		tasks:     tasks,
+		config:    cfg,
+		batches:   make(chan interface{}),
+		waitGroup: waitGroup,
+		abandon:   make(chan struct{})}
+
+	worker, err := newWorker(ctx, b.batches, func(ctx context.Context, batch interface{}) error {
+		return handleBatch(ctx, batch.([]interface{}))
+	}, waitGroup, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b.worker = worker
+
+	waitGroup.Add(1)
+	go b.collect()
+
+	return b, nil
+}
+
+// collect runs on its own goroutine, collecting tasks into batches and flushing them to the worker.
+func (b *BatchWorker) collect() {
+
+	defer b.waitGroup.Done()
+	defer close(b.batches) // drains the worker
+
+	var batch []interface{}
+	var timer *time.Timer
+	var expired <-chan time.Time // nil (never ready) while the batch is empty
+
+	// flush passes the batch to the worker, returning false if the worker was abandoned, or stopped (e.g. per
+	// PanicStop), first
+	flush := func() bool {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+
+		if len(batch) == 0 {
+			return true
+		}
+
+		select {
+		case b.batches <- batch:
+			batch = nil
+			return true
+		case <-b.abandon:
+			return false
+		case <-b.worker.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case task, ok := <-b.tasks:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, task)
+
+			if len(batch) == 1 {
+				// the oldest task in the batch; start the clock
+				timer = time.NewTimer(b.config.MaxDelay)
+				expired = timer.C
+			}
+
+			if len(batch) >= b.config.MaxSize && !flush() {
+				return
+			}
+
+		case <-expired:
+			if !flush() {
+				return
+			}
+
+		case <-b.abandon:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+
+		case <-b.worker.Done():
+			// the worker stopped on its own; there's no one to take the batches
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Abandon instructs the worker to stop in the near future, discarding any partial batch; see Worker.Abandon.
+func (b *BatchWorker) Abandon() {
+	b.abandonOnce.Do(func() { close(b.abandon) })
+	b.worker.Abandon()
+}
+
+// Wait is a blocking call that waits for the worker to stop.
+// IMPORTANT: You must have closed the task channel and/or called Abandon() prior to calling Wait, otherwise a
+// deadlock will occur.
+func (b *BatchWorker) Wait() {
+	b.waitGroup.Wait()
+}
+
+func (b *BatchWorker) String() string {
+	return fmt.Sprintf("&BatchWorker{maxSize:%d maxDelay:%v}", b.config.MaxSize, b.config.MaxDelay)
+}
@@ -0,0 +1,156 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BatchWorker", func() {
+
+	var mutex sync.Mutex
+	var batches [][]interface{}
+
+	record := func(_ context.Context, batch []interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		batches = append(batches, batch)
+		return nil
+	}
+
+	recorded := func() [][]interface{} {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return append([][]interface{}{}, batches...)
+	}
+
+	BeforeEach(func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		batches = nil
+	})
+
+	It("validates its arguments", func() {
+		cfg := BatchConfig{MaxSize: 2, MaxDelay: time.Second}
+
+		_, err := NewBatchWorker(context.Background(), nil, cfg, record, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBatchWorker(context.Background(), make(chan interface{}), cfg, nil, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBatchWorker(nil, make(chan interface{}), cfg, record, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBatchWorker(context.Background(), make(chan interface{}), BatchConfig{MaxDelay: time.Second}, record, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBatchWorker(context.Background(), make(chan interface{}), BatchConfig{MaxSize: 2}, record, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBatchWorker(context.Background(), make(chan interface{}), BatchConfig{MaxSize: -1, MaxDelay: time.Second}, record, nil, nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("flushes a batch once it is full", func(done Done) {
+		tasks := make(chan interface{})
+		w, err := NewBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 3, MaxDelay: time.Minute}, record, nil, nil)
+		Expect(err).To(BeNil())
+
+		for i := 0; i < 6; i++ {
+			tasks <- i
+		}
+
+		Eventually(recorded).Should(Equal([][]interface{}{{0, 1, 2}, {3, 4, 5}}))
+
+		close(tasks)
+		w.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("flushes a partial batch once its oldest task has waited for the maximum delay", func(done Done) {
+		tasks := make(chan interface{})
+		w, err := NewBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 100, MaxDelay: 20 * time.Millisecond}, record, nil, nil)
+		Expect(err).To(BeNil())
+
+		start := time.Now()
+		tasks <- "a"
+		tasks <- "b"
+
+		Eventually(recorded).Should(Equal([][]interface{}{{"a", "b"}}))
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+
+		tasks <- "c"
+		Eventually(recorded).Should(HaveLen(2))
+
+		w.Abandon()
+		w.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("flushes the remaining tasks when the task channel is closed", func(done Done) {
+		tasks := make(chan interface{}, 5)
+		w, err := NewBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 2, MaxDelay: time.Minute}, record, nil, nil)
+		Expect(err).To(BeNil())
+
+		for i := 0; i < 5; i++ {
+			tasks <- i
+		}
+		close(tasks)
+		w.Wait()
+
+		Expect(recorded()).To(Equal([][]interface{}{{0, 1}, {2, 3}, {4}}))
+
+		close(done)
+	}, 3) // timeout
+
+	It("applies the options to whole batches", func(done Done) {
+		failures := make(chan interface{}, 1)
+		attempts := 0
+
+		tasks := make(chan interface{}, 2)
+		w, err := NewBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 2, MaxDelay: time.Minute}, func(context.Context, []interface{}) error {
+			attempts++
+			return fmt.Errorf("failed")
+		}, nil, &Options{
+			Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			OnFailure: func(task interface{}, _ error, _ int) {
+				failures <- task
+			}})
+		Expect(err).To(BeNil())
+
+		tasks <- "a"
+		tasks <- "b"
+		close(tasks)
+		w.Wait()
+
+		Expect(attempts).To(Equal(2))
+		Expect(failures).To(Receive(Equal([]interface{}{"a", "b"})))
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops collecting once the worker stops on its own", func(done Done) {
+		tasks := make(chan interface{})
+		w, err := NewBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 1, MaxDelay: time.Minute}, func(context.Context, []interface{}) error {
+			panic("failed")
+		}, nil, &Options{PanicPolicy: PanicStop})
+		Expect(err).To(BeNil())
+
+		tasks <- "a"
+		w.Wait() // the collector stops too, rather than blocking on the next batch
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,208 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// bulkheadClass holds the state of a task class.
+type bulkheadClass struct {
+	name    string
+	limit   int
+	running int // tasks dispatched to the pool, and not yet done
+	tasks   []interface{}
+	isReady bool // the class is in the ready list
+}
+
+// bulkheadAdmission admits the tasks of each class up to the class's limit (see Bulkhead).
+type bulkheadAdmission struct {
+	classes map[string]*bulkheadClass
+	ready   []*bulkheadClass // classes below their limit, with at least one queued task, in FIFO order
+	queued  int
+	running int
+}
+
+func (a *bulkheadAdmission) push(name string, task interface{}) error {
+
+	c, ok := a.classes[name]
+	if !ok {
+		return fmt.Errorf("unknown task class %q", name)
+	}
+
+	c.tasks = append(c.tasks, task)
+	a.queued++
+	a.markReady(c)
+
+	return nil
+}
+
+func (a *bulkheadAdmission) next() (interface{}, interface{}, bool) {
+
+	if len(a.ready) == 0 {
+		return nil, nil, false
+	}
+
+	c := a.ready[0]
+	a.ready[0] = nil
+	a.ready = a.ready[1:]
+	c.isReady = false
+
+	task := c.tasks[0]
+	c.tasks[0] = nil
+	c.tasks = c.tasks[1:]
+	c.running++
+	a.queued--
+	a.running++
+
+	a.markReady(c) // take turns with the other classes
+
+	return task, c, true
+}
+
+func (a *bulkheadAdmission) release(tag interface{}) {
+
+	c := tag.(*bulkheadClass)
+
+	c.running--
+	a.running--
+	a.markReady(c)
+}
+
+// markReady adds a class to the ready list if it is below its limit and has queued tasks.
+func (a *bulkheadAdmission) markReady(c *bulkheadClass) {
+	if !c.isReady && len(c.tasks) > 0 && c.running < c.limit {
+		c.isReady = true
+		a.ready = append(a.ready, c)
+	}
+}
+
+func (a *bulkheadAdmission) len() int {
+	return a.queued
+}
+
+func (a *bulkheadAdmission) discard() {
+	for _, c := range a.classes {
+		c.tasks = nil
+		c.isReady = false
+	}
+	a.ready = nil
+	a.queued = 0
+}
+
+// Bulkhead partitions the capacity of a WorkerPool among named task classes (as determined by a class function, e.g. by
+// the downstream dependency a task uses): each class has a limit on the number of its tasks that may run at once, so
+// that a class whose tasks are slow or backlogged can't occupy all the pool's workers. Tasks pushed to the Bulkhead are
+// passed to the pool as their class's limit permits; tasks beyond the limit are queued, in order, until one of the
+// class's running tasks is done. Classes with queued tasks take turns to dispatch a task.
+//
+// The pool may run other tasks too (those sent on its task channel, or pushed to another Bulkhead), which are not
+// subject to the limits. If the limits add up to the size of the pool, each class has its own share of the workers; if
+// they add up to more, the classes compete for the excess.
+//
+// The pool's options apply to the Bulkhead's tasks, and the options' hooks receive the tasks as pushed. A task is not
+// done until it has succeeded or finally failed, so a task awaiting a retry (see Options.Retry) counts against its
+// class's limit. If the pool is abandoned or shut down, the Bulkhead stops, discarding its queued tasks.
+// THREAD-SAFETY: the Bulkhead is thread-safe.
+type Bulkhead struct {
+	dispatcher *dispatcher
+	admission  *bulkheadAdmission // covered by the dispatcher's mutex
+}
+
+// NewBulkhead creates a Bulkhead that partitions the capacity of 'pool'; 'limits' maps each class name to the maximum
+// number of tasks of the class that may run at once, and 'classOf' returns the class of a task. The pool's workers run
+// each task pushed by calling handleTask, or the pool's own handler if 'handleTask' is nil; the context passed to
+// handleTask is additionally cancelled if the Bulkhead is abandoned, or 'ctx' is done.
+// NewBulkhead will return an error if the provided context, pool, or class func are nil, or if there are no classes or
+// a limit is not positive.
+func NewBulkhead(ctx context.Context, pool *WorkerPool, limits map[string]int, classOf func(task interface{}) string, handleTask ContextHandler) (*Bulkhead, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("context cannot be nil")
+	}
+
+	if pool == nil {
+		return nil, fmt.Errorf("pool cannot be nil")
+	}
+
+	if classOf == nil {
+		return nil, fmt.Errorf("classOf func cannot be nil")
+	}
+
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("at least one class is required")
+	}
+
+	classes := make(map[string]*bulkheadClass, len(limits))
+	for name, limit := range limits {
+		if limit < 1 {
+			return nil, fmt.Errorf("the limit of class %q must be positive (%d)", name, limit)
+		}
+
+		classes[name] = &bulkheadClass{name: name, limit: limit}
+	}
+
+	if handleTask == nil {
+		handleTask = pool.handleTask
+	}
+
+	admission := &bulkheadAdmission{classes: classes}
+
+	return &Bulkhead{
+		dispatcher: newSharingDispatcher(ctx, pool, classOf, handleTask, admission),
+		admission:  admission}, nil
+}
+
+// Push queues a task behind any queued tasks of its class. Push does not block.
+// An error is returned if the task's class is unknown, or on an attempt to push to a closed or abandoned bulkhead.
+func (b *Bulkhead) Push(task interface{}) error {
+	return b.dispatcher.push(task)
+}
+
+// Len returns the number of queued tasks (not including running tasks).
+func (b *Bulkhead) Len() int {
+	return b.dispatcher.len()
+}
+
+// Usage returns the number of running and queued tasks of a class; both are zero for an unknown class.
+func (b *Bulkhead) Usage(class string) (running int, queued int) {
+	b.dispatcher.withLock(func() {
+		if c, ok := b.admission.classes[class]; ok {
+			running, queued = c.running, len(c.tasks)
+		}
+	})
+
+	return running, queued
+}
+
+// Close stops the bulkhead from accepting further tasks; the remaining tasks will be run. The pool is not stopped.
+// Close is non-blocking; use Wait to wait for the remaining tasks to be done.
+func (b *Bulkhead) Close() {
+	b.dispatcher.close()
+}
+
+// Abandon stops the bulkhead from accepting further tasks, discards any queued tasks, and cancels the context passed to
+// its running tasks. The pool is not abandoned, and its other tasks are unaffected. Abandon is non-blocking.
+func (b *Bulkhead) Abandon() {
+	b.dispatcher.abandon()
+}
+
+// Wait blocks until the bulkhead has stopped: having been closed, and its tasks done, or having been abandoned (in which
+// case its cancelled tasks may still be returning).
+func (b *Bulkhead) Wait() {
+	b.dispatcher.wait()
+}
+
+func (b *Bulkhead) String() string {
+	var names []string
+	var running, queued int
+	b.dispatcher.withLock(func() {
+		for name := range b.admission.classes {
+			names = append(names, name)
+		}
+		running, queued = b.admission.running, b.admission.queued
+	})
+	sort.Strings(names)
+
+	return fmt.Sprintf("&Bulkhead{classes:%v running:%d queued:%d}", names, running, queued)
+}
@@ -0,0 +1,278 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type classTestTask struct {
+	Class string
+	Seq   int
+}
+
+var _ = Describe("Bulkhead", func() {
+
+	classOf := func(task interface{}) string {
+		return task.(*classTestTask).Class
+	}
+
+	limits := map[string]int{"slow": 2, "fast": 2}
+
+	var tasks chan interface{}
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		tasks = make(chan interface{})
+
+		var err error
+		pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(context.Context, interface{}) error {
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("validates its arguments", func() {
+		handler := func(context.Context, interface{}) error { return nil }
+
+		_, err := NewBulkhead(nil, pool, limits, classOf, handler)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBulkhead(context.Background(), nil, limits, classOf, handler)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBulkhead(context.Background(), pool, nil, classOf, handler)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBulkhead(context.Background(), pool, map[string]int{"a": 0}, classOf, handler)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewBulkhead(context.Background(), pool, limits, nil, handler)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("keeps a backlogged class from occupying all the workers", func(done Done) {
+		Expect(pool.Add(4)).To(Succeed())
+
+		release := make(chan struct{})
+		var fastRan int32
+
+		var mutex sync.Mutex
+		var order []int
+
+		b, err := NewBulkhead(context.Background(), pool, limits, classOf, func(_ context.Context, task interface{}) error {
+			t := task.(*classTestTask)
+			if t.Class == "slow" {
+				<-release
+				mutex.Lock()
+				order = append(order, t.Seq)
+				mutex.Unlock()
+			} else {
+				atomic.AddInt32(&fastRan, 1)
+			}
+			return nil
+		})
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 10; seq++ {
+			Expect(b.Push(&classTestTask{Class: "slow", Seq: seq})).To(Succeed())
+		}
+		for seq := 0; seq < 10; seq++ {
+			Expect(b.Push(&classTestTask{Class: "fast", Seq: seq})).To(Succeed())
+		}
+		Expect(b.Push(&classTestTask{Class: "unknown"})).NotTo(Succeed())
+
+		Eventually(func() int32 { return atomic.LoadInt32(&fastRan) }).Should(Equal(int32(10)))
+
+		running, queued := b.Usage("slow")
+		Expect(running).To(Equal(2))
+		Expect(queued).To(Equal(8))
+		Expect(b.Len()).To(Equal(8))
+
+		b.Close()
+		close(release)
+		b.Wait()
+
+		Expect(order).To(ConsistOf(0, 1, 2, 3, 4, 5, 6, 7, 8, 9))
+		Expect(b.Len()).To(Equal(0))
+		Expect(pool.Size()).To(Equal(4)) // the pool is not stopped
+
+		close(done)
+	}, 3) // timeout
+
+	It("shares the pool with its other tasks, and runs the pool's handler by default", func(done Done) {
+		handled := make(chan interface{}, 10)
+		release := make(chan struct{})
+
+		shared, err := NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+			if t, ok := task.(*classTestTask); ok && t.Class == "a" {
+				<-release
+			}
+			handled <- task
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+		defer shared.Abandon()
+		Expect(shared.Add(2)).To(Succeed())
+
+		b, err := NewBulkhead(context.Background(), shared, map[string]int{"a": 1}, classOf, nil)
+		Expect(err).To(BeNil())
+
+		Expect(b.Push(&classTestTask{Class: "a", Seq: 1})).To(Succeed())
+		Expect(b.Push(&classTestTask{Class: "a", Seq: 2})).To(Succeed())
+		Eventually(func() int { running, _ := b.Usage("a"); return running }).Should(Equal(1))
+
+		tasks <- "direct" // the other worker is free
+		Eventually(handled).Should(Receive(Equal("direct")))
+
+		close(release)
+		Eventually(handled).Should(Receive(Equal(&classTestTask{Class: "a", Seq: 1})))
+		Eventually(handled).Should(Receive(Equal(&classTestTask{Class: "a", Seq: 2})))
+
+		b.Close()
+		b.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("passes the tasks themselves to the pool's hooks", func(done Done) {
+		failures := make(chan interface{}, 1)
+
+		failing, err := NewWorkerPoolWithContext(context.Background(), make(chan interface{}), func(context.Context, interface{}) error {
+			return nil
+		}, &Options{
+			Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			OnFailure: func(task interface{}, _ error, _ int) {
+				failures <- task
+			}})
+		Expect(err).To(BeNil())
+		defer failing.Abandon()
+		Expect(failing.Add(1)).To(Succeed())
+
+		b, err := NewBulkhead(context.Background(), failing, map[string]int{"a": 1}, classOf, func(context.Context, interface{}) error {
+			return fmt.Errorf("failed")
+		})
+		Expect(err).To(BeNil())
+
+		Expect(b.Push(&classTestTask{Class: "a"})).To(Succeed())
+		Eventually(failures).Should(Receive(Equal(&classTestTask{Class: "a"})))
+
+		b.Close()
+		b.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("discards queued tasks and cancels its running tasks when abandoned, or when its context is cancelled", func(done Done) {
+		Expect(pool.Add(2)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var ran int32
+		cancelled := make(chan struct{})
+
+		b, err := NewBulkhead(ctx, pool, map[string]int{"a": 1}, classOf, func(ctx context.Context, _ interface{}) error {
+			atomic.AddInt32(&ran, 1)
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		})
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 5; seq++ {
+			Expect(b.Push(&classTestTask{Class: "a", Seq: seq})).To(Succeed())
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&ran) }).Should(Equal(int32(1)))
+		Expect(b.Len()).To(Equal(4))
+
+		cancel()
+		b.Wait()
+		Eventually(cancelled).Should(BeClosed())
+
+		Expect(atomic.LoadInt32(&ran)).To(Equal(int32(1)))
+		Expect(b.Len()).To(Equal(0))
+		Expect(b.Push(&classTestTask{Class: "a"})).NotTo(Succeed())
+
+		Expect(pool.Size()).To(Equal(2)) // the pool is not abandoned
+
+		close(done)
+	}, 3) // timeout
+
+	It("lets its running tasks finish when the pool is shut down while it is waiting to pass a task", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+
+		release := make(chan struct{})
+		errs := make(chan error, 3)
+		b, err := NewBulkhead(context.Background(), pool, map[string]int{"a": 2}, classOf, func(ctx context.Context, _ interface{}) error {
+			<-release
+			errs <- ctx.Err()
+			return nil
+		})
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 3; seq++ {
+			Expect(b.Push(&classTestTask{Class: "a", Seq: seq})).To(Succeed())
+		}
+		Eventually(func() int { running, _ := b.Usage("a"); return running }).Should(Equal(2)) // one blocked in the pool
+
+		shutdown := make(chan error, 1)
+		go func() {
+			_, err := pool.Shutdown(context.Background())
+			shutdown <- err
+		}()
+
+		b.Wait()
+		Expect(b.Len()).To(Equal(0))
+
+		close(release)
+		Eventually(shutdown).Should(Receive(BeNil()))
+		Expect(errs).To(Receive(BeNil())) // the running task was not cancelled
+		Expect(errs).NotTo(Receive())
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops when the pool is shut down", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+
+		release := make(chan struct{})
+		b, err := NewBulkhead(context.Background(), pool, map[string]int{"a": 1}, classOf, func(ctx context.Context, _ interface{}) error {
+			<-release
+			return ctx.Err()
+		})
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 3; seq++ {
+			Expect(b.Push(&classTestTask{Class: "a", Seq: seq})).To(Succeed())
+		}
+		Eventually(func() int { running, _ := b.Usage("a"); return running }).Should(Equal(1))
+
+		shutdown := make(chan error, 1)
+		go func() {
+			_, err := pool.Shutdown(context.Background())
+			shutdown <- err
+		}()
+
+		b.Wait()
+		Expect(b.Len()).To(Equal(0))
+		Expect(b.Push(&classTestTask{Class: "a"})).NotTo(Succeed())
+
+		close(release) // the running task finishes as the pool drains
+		Eventually(shutdown).Should(Receive(BeNil()))
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,406 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// ErrCircuitOpen is returned in place of running a task while a CircuitBreaker (in CircuitFailFast mode) is open, or
+// while its half-open trial tasks are in progress.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed runs tasks normally, while tracking their failures.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects (or parks) tasks until the cooldown has elapsed.
+	CircuitOpen
+
+	// CircuitHalfOpen runs a limited number of trial tasks: if they all succeed the breaker closes, and if any fails
+	// the breaker opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "CircuitClosed"
+	case CircuitOpen:
+		return "CircuitOpen"
+	case CircuitHalfOpen:
+		return "CircuitHalfOpen"
+	}
+
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOpenPolicy selects what a CircuitBreaker does with a task that is not permitted to run.
+type CircuitOpenPolicy int
+
+const (
+	// CircuitFailFast fails the task with ErrCircuitOpen. This is the default.
+	CircuitFailFast CircuitOpenPolicy = iota
+
+	// CircuitPark blocks the task until it is permitted to run, or until its context is done.
+	CircuitPark
+)
+
+func (p CircuitOpenPolicy) String() string {
+	switch p {
+	case CircuitFailFast:
+		return "CircuitFailFast"
+	case CircuitPark:
+		return "CircuitPark"
+	}
+
+	return fmt.Sprintf("CircuitOpenPolicy(%d)", int(p))
+}
+
+// CircuitBreakerConfig configures a CircuitBreaker. At least one of ConsecutiveFailures and FailureRate must be set;
+// the breaker trips on whichever threshold is reached first.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures trips the breaker after this many consecutive failures; zero disables the threshold.
+	ConsecutiveFailures int
+
+	// FailureRate trips the breaker once the proportion of failures among the last Window results reaches this rate,
+	// in (0, 1]; zero disables the threshold.
+	FailureRate float64
+	Window      int // the number of results over which FailureRate is computed; required with FailureRate
+	MinResults  int // the number of results needed before FailureRate applies; the default is Window
+
+	Cooldown       time.Duration `config:"required"` // how long the breaker stays open before going half-open
+	HalfOpenTrials int           // the number of trial tasks run while half-open; the default is 1
+
+	OpenPolicy CircuitOpenPolicy
+
+	// IsFailure classifies the errors returned by tasks; errors for which it returns false count as successes. If nil,
+	// all non-nil errors are failures. context.Canceled is never counted either way.
+	IsFailure func(err error) bool
+
+	// OnStateChange, if non-nil, is called on each change of state, on the goroutine of the task that caused it (or
+	// that observed the end of the cooldown).
+	OnStateChange func(from CircuitState, to CircuitState)
+}
+
+// CircuitBreaker stops tasks from running against a failing dependency: once tasks fail often enough (see
+// CircuitBreakerConfig), the breaker opens, and tasks are failed fast with ErrCircuitOpen (or parked, see CircuitPark)
+// instead of being run. After a cooldown the breaker goes half-open and runs a few trial tasks, which either close the
+// breaker or open it again.
+//
+// Wrap a handler to guard the tasks of a Worker or WorkerPool:
+//
+//	breaker, err := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 5, Cooldown: 10 * time.Second})
+//	pool, err := NewWorkerPoolWithContext(ctx, tasks, breaker.Wrap(handleTask), opts)
+//
+// Tasks failed with ErrCircuitOpen are reported to Options.OnError, and are subject to Options.Retry.
+//
+// THREAD-SAFETY: the CircuitBreaker is thread-safe.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	state      CircuitState
+	generation uint64        // incremented on each change of state; results from an earlier state are discarded
+	changed    chan struct{} // closed (and replaced) on each change of state, or when a trial slot frees up
+	openedAt   time.Time
+
+	// closed state
+	consecutive int
+	results     []bool // ring buffer of the last Window results; true for a failure
+	next        int    // the next index into results
+	count       int    // the number of valid entries in results
+	failures    int    // the number of failures in results
+
+	// half-open state
+	trials    int // trial tasks admitted
+	successes int // trial tasks succeeded
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker.
+// NewCircuitBreaker will return an error if the config is invalid.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) (*CircuitBreaker, error) {
+
+	if err := config.ValidateConstraints(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid circuit breaker config: %v", err)
+	}
+
+	if cfg.ConsecutiveFailures < 0 || cfg.Window < 0 || cfg.MinResults < 0 || cfg.HalfOpenTrials < 0 || cfg.Cooldown < 0 {
+		return nil, fmt.Errorf("invalid circuit breaker config: values cannot be negative")
+	}
+
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 || math.IsNaN(cfg.FailureRate) {
+		return nil, fmt.Errorf("invalid circuit breaker config: FailureRate must be in [0, 1] (%v)", cfg.FailureRate)
+	}
+
+	if cfg.ConsecutiveFailures == 0 && cfg.FailureRate == 0 {
+		return nil, fmt.Errorf("invalid circuit breaker config: one of ConsecutiveFailures and FailureRate is required")
+	}
+
+	if cfg.FailureRate > 0 {
+		if cfg.Window == 0 {
+			return nil, fmt.Errorf("invalid circuit breaker config: Window is required with FailureRate")
+		}
+
+		if cfg.MinResults == 0 || cfg.MinResults > cfg.Window {
+			cfg.MinResults = cfg.Window
+		}
+	}
+
+	if cfg.HalfOpenTrials == 0 {
+		cfg.HalfOpenTrials = 1
+	}
+
+	return &CircuitBreaker{
+		config:  cfg,
+		mutex:   sync.Mutex{},
+		state:   CircuitClosed,
+		changed: make(chan struct{}),
+		results: make([]bool, cfg.Window)}, nil
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+
+	b.mutex.Lock()
+	state, from, changed := b.refresh(time.Now())
+	b.mutex.Unlock()
+
+	b.notify(from, state, changed)
+
+	return state
+}
+
+// Do runs 'fn' if the breaker permits it, and records the result. If the breaker does not permit it, Do returns
+// ErrCircuitOpen (CircuitFailFast), or waits until it does, returning the context's error if the context is done first
+// (CircuitPark).
+func (b *CircuitBreaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	generation, err := b.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	isComplete := false
+	defer func() {
+		if !isComplete {
+			b.record(generation, true) // the func panicked
+		}
+	}()
+
+	err = fn(ctx)
+	isComplete = true
+
+	if err != context.Canceled {
+		b.record(generation, b.isFailure(err))
+	} else {
+		b.release(generation)
+	}
+
+	return err
+}
+
+// Wrap returns a handler that runs 'handleTask' via Do.
+func (b *CircuitBreaker) Wrap(handleTask ContextHandler) ContextHandler {
+	return func(ctx context.Context, task interface{}) error {
+		return b.Do(ctx, func(ctx context.Context) error {
+			return handleTask(ctx, task)
+		})
+	}
+}
+
+func (b *CircuitBreaker) String() string {
+	return fmt.Sprintf("&CircuitBreaker{state:%v}", b.State())
+}
+
+func (b *CircuitBreaker) isFailure(err error) bool {
+
+	if b.config.IsFailure != nil {
+		return b.config.IsFailure(err)
+	}
+
+	return err != nil
+}
+
+// acquire waits for (or fails) permission to run a task, returning the generation in which permission was granted.
+func (b *CircuitBreaker) acquire(ctx context.Context) (uint64, error) {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		now := time.Now()
+
+		b.mutex.Lock()
+
+		state, from, isChanged := b.refresh(now)
+		generation, changed, reopen := b.generation, b.changed, b.openedAt.Add(b.config.Cooldown)
+
+		isPermitted := state == CircuitClosed
+		if state == CircuitHalfOpen && b.trials < b.config.HalfOpenTrials {
+			b.trials++
+			isPermitted = true
+		}
+
+		b.mutex.Unlock()
+
+		b.notify(from, state, isChanged)
+
+		if isPermitted {
+			return generation, nil
+		}
+
+		if b.config.OpenPolicy != CircuitPark {
+			return 0, ErrCircuitOpen
+		}
+
+		// park until the state changes, or (if open) until the cooldown has elapsed
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if state == CircuitOpen {
+			timer = time.NewTimer(reopen.Sub(now))
+			expired = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+		case <-ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// record records the result of a task that was permitted to run in the provided generation.
+func (b *CircuitBreaker) record(generation uint64, isFailure bool) {
+
+	b.mutex.Lock()
+
+	if generation != b.generation {
+		b.mutex.Unlock()
+		return // the state has since changed
+	}
+
+	from := b.state
+
+	switch b.state {
+	case CircuitClosed:
+		if isFailure {
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+
+		if b.config.FailureRate > 0 {
+			if b.count == len(b.results) {
+				if b.results[b.next] {
+					b.failures--
+				}
+			} else {
+				b.count++
+			}
+
+			b.results[b.next] = isFailure
+			b.next = (b.next + 1) % len(b.results)
+
+			if isFailure {
+				b.failures++
+			}
+		}
+
+		if b.isTripped() {
+			b.transition(CircuitOpen, time.Now())
+		}
+
+	case CircuitHalfOpen:
+		if isFailure {
+			b.transition(CircuitOpen, time.Now())
+		} else if b.successes++; b.successes == b.config.HalfOpenTrials {
+			b.transition(CircuitClosed, time.Now())
+		}
+	}
+
+	to := b.state
+	b.mutex.Unlock()
+
+	b.notify(from, to, from != to)
+}
+
+// release returns the permission granted to a task whose result is not counted.
+func (b *CircuitBreaker) release(generation uint64) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if generation == b.generation && b.state == CircuitHalfOpen {
+		b.trials--
+		b.broadcast() // a parked task may take the trial
+	}
+}
+
+// isTripped determines if the closed breaker has reached a threshold; the caller must hold the mutex.
+func (b *CircuitBreaker) isTripped() bool {
+
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+
+	return b.config.FailureRate > 0 && b.count >= b.config.MinResults &&
+		float64(b.failures)/float64(b.count) >= b.config.FailureRate
+}
+
+// refresh moves an open breaker to half-open once its cooldown has elapsed, returning the (new) state, the previous
+// state, and whether the state changed; the caller must hold the mutex.
+func (b *CircuitBreaker) refresh(now time.Time) (state CircuitState, from CircuitState, isChanged bool) {
+
+	from = b.state
+
+	if b.state == CircuitOpen && !now.Before(b.openedAt.Add(b.config.Cooldown)) {
+		b.transition(CircuitHalfOpen, now)
+	}
+
+	return b.state, from, b.state != from
+}
+
+// transition changes the state, resetting the per-state counters; the caller must hold the mutex.
+func (b *CircuitBreaker) transition(to CircuitState, now time.Time) {
+
+	b.state = to
+	b.generation++
+
+	b.consecutive, b.next, b.count, b.failures = 0, 0, 0, 0
+	b.trials, b.successes = 0, 0
+
+	if to == CircuitOpen {
+		b.openedAt = now
+	}
+
+	b.broadcast()
+}
+
+// broadcast wakes the parked tasks; the caller must hold the mutex.
+func (b *CircuitBreaker) broadcast() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// notify calls the OnStateChange callback if the state changed; the caller must not hold the mutex.
+func (b *CircuitBreaker) notify(from CircuitState, to CircuitState, isChanged bool) {
+	if isChanged && b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
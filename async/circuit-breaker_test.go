@@ -0,0 +1,240 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+
+	failure := fmt.Errorf("failure")
+
+	succeed := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return failure }
+
+	It("is a Stringer", func() {
+		var _ fmt.Stringer = (*CircuitBreaker)(nil)
+
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, Cooldown: time.Second})
+		Expect(err).To(BeNil())
+		Expect(fmt.Sprintf("%v", b)).To(ContainSubstring("CircuitClosed"))
+	})
+
+	It("validates its config", func() {
+		for _, cfg := range []CircuitBreakerConfig{
+			{ConsecutiveFailures: 1},                              // no cooldown
+			{Cooldown: time.Second},                               // no threshold
+			{ConsecutiveFailures: -1, Cooldown: time.Second},      // negative
+			{FailureRate: 0.5, Cooldown: time.Second},             // no window
+			{FailureRate: 1.5, Window: 10, Cooldown: time.Second}, // out of range
+		} {
+			_, err := NewCircuitBreaker(cfg)
+			Expect(err).To(HaveOccurred(), fmt.Sprintf("%+v", cfg))
+		}
+	})
+
+	It("trips after consecutive failures, and fails fast while open", func() {
+		var changes []string
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			Cooldown:            time.Hour,
+			OnStateChange: func(from CircuitState, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%v->%v", from, to))
+			}})
+		Expect(err).To(BeNil())
+
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Expect(b.Do(context.Background(), succeed)).To(Succeed()) // resets the count
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Expect(b.State()).To(Equal(CircuitClosed))
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Expect(b.State()).To(Equal(CircuitOpen))
+
+		ran := false
+		Expect(b.Do(context.Background(), func(context.Context) error {
+			ran = true
+			return nil
+		})).To(Equal(ErrCircuitOpen))
+		Expect(ran).To(BeFalse())
+
+		Expect(changes).To(Equal([]string{"CircuitClosed->CircuitOpen"}))
+	})
+
+	It("trips on the failure rate over a window", func() {
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{FailureRate: 0.5, Window: 4, Cooldown: time.Hour})
+		Expect(err).To(BeNil())
+
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Expect(b.Do(context.Background(), succeed)).To(Succeed())
+		Expect(b.State()).To(Equal(CircuitClosed)) // fewer than Window results
+
+		Expect(b.Do(context.Background(), succeed)).To(Succeed())
+		Expect(b.State()).To(Equal(CircuitOpen)) // 2 of 4
+	})
+
+	It("does not count errors that aren't failures, or cancellations", func() {
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			Cooldown:            time.Hour,
+			IsFailure:           func(err error) bool { return err == failure }})
+		Expect(err).To(BeNil())
+
+		Expect(b.Do(context.Background(), func(context.Context) error { return fmt.Errorf("not found") })).NotTo(Succeed())
+		Expect(b.Do(context.Background(), func(context.Context) error { return context.Canceled })).NotTo(Succeed())
+		Expect(b.State()).To(Equal(CircuitClosed))
+	})
+
+	It("goes half-open after the cooldown, and closes once the trial tasks succeed", func() {
+		var changes []string
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			Cooldown:            20 * time.Millisecond,
+			HalfOpenTrials:      2,
+			OnStateChange: func(from CircuitState, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%v->%v", from, to))
+			}})
+		Expect(err).To(BeNil())
+
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Eventually(b.State).Should(Equal(CircuitHalfOpen))
+
+		Expect(b.Do(context.Background(), succeed)).To(Succeed())
+		Expect(b.State()).To(Equal(CircuitHalfOpen))
+		Expect(b.Do(context.Background(), succeed)).To(Succeed())
+		Expect(b.State()).To(Equal(CircuitClosed))
+
+		Expect(changes).To(Equal([]string{
+			"CircuitClosed->CircuitOpen",
+			"CircuitOpen->CircuitHalfOpen",
+			"CircuitHalfOpen->CircuitClosed"}))
+	})
+
+	It("limits the trial tasks, and reopens if a trial task fails", func(done Done) {
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, Cooldown: 20 * time.Millisecond})
+		Expect(err).To(BeNil())
+
+		Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		Eventually(b.State).Should(Equal(CircuitHalfOpen))
+
+		started, release := make(chan struct{}), make(chan struct{})
+		result := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			result <- b.Do(context.Background(), func(context.Context) error {
+				close(started)
+				<-release
+				return failure
+			})
+		}()
+
+		<-started
+		Expect(b.Do(context.Background(), succeed)).To(Equal(ErrCircuitOpen))
+
+		close(release)
+		Eventually(result).Should(Receive(Equal(failure)))
+		Expect(b.State()).To(Equal(CircuitOpen))
+
+		close(done)
+	}, 3) // timeout
+
+	It("counts a panic as a failure", func() {
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, Cooldown: time.Hour})
+		Expect(err).To(BeNil())
+
+		Expect(func() {
+			b.Do(context.Background(), func(context.Context) error { panic("boom") })
+		}).To(Panic())
+		Expect(b.State()).To(Equal(CircuitOpen))
+	})
+
+	Describe("CircuitPark", func() {
+
+		var b *CircuitBreaker
+
+		BeforeEach(func() {
+			var err error
+			b, err = NewCircuitBreaker(CircuitBreakerConfig{
+				ConsecutiveFailures: 1,
+				Cooldown:            50 * time.Millisecond,
+				OpenPolicy:          CircuitPark})
+			Expect(err).To(BeNil())
+
+			Expect(b.Do(context.Background(), fail)).To(Equal(failure))
+		})
+
+		It("parks tasks until the breaker permits them", func(done Done) {
+			start := time.Now()
+
+			var wg sync.WaitGroup
+			var ran int32
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(b.Do(context.Background(), func(context.Context) error {
+						atomic.AddInt32(&ran, 1)
+						return nil
+					})).To(Succeed())
+				}()
+			}
+
+			wg.Wait()
+			Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+			Expect(atomic.LoadInt32(&ran)).To(Equal(int32(3)))
+			Expect(b.State()).To(Equal(CircuitClosed))
+
+			close(done)
+		}, 3) // timeout
+
+		It("stops parking a task once its context is done", func(done Done) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			Expect(b.Do(ctx, succeed)).To(Equal(context.DeadlineExceeded))
+			Expect(b.State()).To(Equal(CircuitOpen))
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	It("wraps the handler of a WorkerPool", func(done Done) {
+		tasks := make(chan interface{})
+		errs := make(chan error, 10)
+
+		b, err := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 2, Cooldown: time.Hour})
+		Expect(err).To(BeNil())
+
+		var handled int32
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, b.Wrap(func(context.Context, interface{}) error {
+			atomic.AddInt32(&handled, 1)
+			return failure
+		}), &Options{OnError: func(_ interface{}, err error) { errs <- err }})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		for i := 0; i < 4; i++ {
+			tasks <- i
+		}
+
+		Eventually(errs).Should(Receive(Equal(failure)))
+		Eventually(errs).Should(Receive(Equal(failure)))
+		Eventually(errs).Should(Receive(Equal(ErrCircuitOpen)))
+		Eventually(errs).Should(Receive(Equal(ErrCircuitOpen)))
+		Expect(atomic.LoadInt32(&handled)).To(Equal(int32(2)))
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,312 @@
+package async
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule computes the run times of a recurring job (see ParseCronSchedule).
+type CronSchedule interface {
+	// Next returns the first run time strictly after 't', or the zero time if there is none (within five years).
+	Next(t time.Time) time.Time
+}
+
+// ParseCronSchedule parses a cron expression, in one of the following forms:
+//
+//	"min hour day-of-month month day-of-week"          (standard 5-field syntax)
+//	"sec min hour day-of-month month day-of-week"      (6-field syntax, with seconds)
+//	"@yearly" (or "@annually"), "@monthly", "@weekly", "@daily" (or "@midnight"), "@hourly"
+//	"@every <duration>"                                (e.g. "@every 1h30m"; see time.ParseDuration)
+//
+// Each field is a comma-separated list of values, ranges ("a-b"), or "*", each optionally followed by a step ("/n").
+// Months and days of the week may be given by their (case-insensitive) three-letter names, e.g. "JAN" or "mon"; day
+// of the week 0 (and 7) is Sunday. "?" may be used in place of "*". As in standard cron, if both the day-of-month and
+// day-of-week fields are restricted (i.e. not just "*"), a day matches if either field matches.
+//
+// The times are evaluated in 'loc' (time.Local if nil), unless the expression starts with a "CRON_TZ=<zone>" (or
+// "TZ=<zone>") prefix, e.g. "CRON_TZ=America/New_York 0 3 * * *".
+func ParseCronSchedule(spec string, loc *time.Location) (CronSchedule, error) {
+
+	if loc == nil {
+		loc = time.Local
+	}
+
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron expression has a time zone but no schedule: %q", spec)
+		}
+
+		zone := spec[strings.Index(spec, "=")+1 : i]
+
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("invalid time zone in cron expression %q: %v", spec, err)
+		}
+
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in cron expression %q", spec)
+		}
+
+		return everySchedule{interval: interval}, nil
+	}
+
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression must have 5 or 6 fields: %q", spec)
+	}
+
+	s := &cronSpec{location: loc}
+
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&s.second, 0, 59, nil},
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dayOfMonth, 1, 31, nil},
+		{&s.month, 1, 12, cronMonthNames},
+		{&s.dayOfWeek, 0, 7, cronDayNames},
+	}
+
+	for i, field := range fields {
+		bits, err := parseCronField(field, bounds[i].min, bounds[i].max, bounds[i].names)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+		*bounds[i].bits = bits
+	}
+
+	// day of the week 7 is Sunday
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek = s.dayOfWeek&^(1<<7) | 1
+	}
+
+	s.isAnyDayOfMonth = isCronWildcard(fields[3])
+	s.isAnyDayOfWeek = isCronWildcard(fields[5])
+
+	return s, nil
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func isCronWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseCronField parses a single field, returning the set of matching values as a bitmask.
+func parseCronField(field string, min int, max int, names map[string]int) (uint64, error) {
+
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step, hasStep := part, 1, false
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, hasStep = part[:i], true
+		}
+
+		var low, high int
+		var err error
+
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			low, high = min, max
+
+		case strings.Contains(rangePart, "-"):
+			i := strings.Index(rangePart, "-")
+			if low, err = parseCronValue(rangePart[:i], names); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(rangePart[i+1:], names); err != nil {
+				return 0, err
+			}
+
+		default:
+			if low, err = parseCronValue(rangePart, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if hasStep {
+				high = max // "a/n" means from a to the maximum, every n
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range [%d, %d]", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	return n, nil
+}
+
+// cronSpec is a parsed cron expression; each field is a bitmask of the matching values.
+type cronSpec struct {
+	second, minute, hour, dayOfMonth, month, dayOfWeek uint64
+	isAnyDayOfMonth, isAnyDayOfWeek                    bool
+	location                                           *time.Location
+}
+
+func (s *cronSpec) dayMatches(t time.Time) bool {
+
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.isAnyDayOfMonth || s.isAnyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time after 't' that matches the expression. The search proceeds from the largest field to
+// the smallest, advancing to the start of the next month, day, etc. whenever a field doesn't match.
+func (s *cronSpec) Next(t time.Time) time.Time {
+
+	t = t.In(s.location)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())) // the next whole second
+
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = s.date(t.Year(), t.Month()+1, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = s.date(t.Year(), t.Month(), t.Day()+1, 0)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		day := t.Day()
+		t = s.nextHour(t)
+		if t.Day() != day {
+			goto wrap
+		}
+	}
+
+	// Within the hour, minutes and seconds are advanced in absolute time (rather than via time.Date, which would
+	// resolve a repeated wall-clock time to its first occurrence, and could move the search backwards)
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		prev := t
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			t = s.nextHour(prev)
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		prev := t
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			if t.Minute() == 0 {
+				t = s.nextHour(prev)
+			}
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// nextHour returns the start of the wall-clock hour following that of 't'. At the end of daylight saving time, this
+// skips the repeated hour, so that each wall-clock time runs at most once; at the start of daylight saving time, the
+// skipped hour is (necessarily) skipped.
+func (s *cronSpec) nextHour(t time.Time) time.Time {
+	return s.date(t.Year(), t.Month(), t.Day(), t.Hour()+1)
+}
+
+// date is time.Date in the spec's location, except that a wall-clock time that doesn't exist (i.e. that falls in the
+// gap at the start of daylight saving time) resolves to the end of the gap; time.Date may resolve it to a time before
+// the gap, which would move the search backwards.
+func (s *cronSpec) date(year int, month time.Month, day int, hour int) time.Time {
+
+	t := time.Date(year, month, day, hour, 0, 0, 0, s.location)
+
+	want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+
+	if wall.Before(want) {
+		t = t.Add(want.Sub(wall))
+	}
+
+	return t
+}
+
+// everySchedule runs at a fixed interval ("@every").
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
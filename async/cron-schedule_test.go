@@ -0,0 +1,119 @@
+package async_test
+
+import (
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseCronSchedule", func() {
+
+	// Mon 2018-01-01 12:34:56 UTC
+	start := time.Date(2018, time.January, 1, 12, 34, 56, 0, time.UTC)
+
+	next := func(spec string, from time.Time) time.Time {
+		schedule, err := ParseCronSchedule(spec, time.UTC)
+		Expect(err).To(BeNil())
+		return schedule.Next(from)
+	}
+
+	at := func(month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(2018, month, day, hour, min, sec, 0, time.UTC)
+	}
+
+	It("rejects invalid expressions", func() {
+		for _, spec := range []string{
+			"",
+			"* * * *",
+			"* * * * * * *",
+			"60 * * * *",
+			"* 24 * * *",
+			"* * 0 * *",
+			"* * * 13 *",
+			"* * * * 8",
+			"5-1 * * * *",
+			"*/0 * * * *",
+			"x * * * *",
+			"* * * FOO *",
+			"@every",
+			"@every -1s",
+			"@fortnightly",
+			"CRON_TZ=Nowhere/Special * * * * *",
+			"CRON_TZ=UTC"} {
+
+			_, err := ParseCronSchedule(spec, nil)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+
+	It("computes the next run time of 5-field expressions", func() {
+		Expect(next("* * * * *", start)).To(Equal(at(time.January, 1, 12, 35, 0)))
+		Expect(next("0 * * * *", start)).To(Equal(at(time.January, 1, 13, 0, 0)))
+		Expect(next("30 3 * * *", start)).To(Equal(at(time.January, 2, 3, 30, 0)))
+		Expect(next("*/15 * * * *", start)).To(Equal(at(time.January, 1, 12, 45, 0)))
+		Expect(next("10-20/5 14 * * *", start)).To(Equal(at(time.January, 1, 14, 10, 0)))
+		Expect(next("0 0 1,15 * *", start)).To(Equal(at(time.January, 15, 0, 0, 0)))
+		Expect(next("0 0 * FEB *", start)).To(Equal(at(time.February, 1, 0, 0, 0)))
+		Expect(next("0 9 * * fri", start)).To(Equal(at(time.January, 5, 9, 0, 0)))
+		Expect(next("0 9 * * 7", start)).To(Equal(at(time.January, 7, 9, 0, 0))) // Sunday
+		Expect(next("0 9 * * MON-WED", start)).To(Equal(at(time.January, 2, 9, 0, 0)))
+		Expect(next("0 0 29 2 *", start)).To(Equal(time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("matches either restricted day field, as standard cron does", func() {
+		// the 13th, or any Friday
+		Expect(next("0 0 13 * 5", start)).To(Equal(at(time.January, 5, 0, 0, 0)))
+		Expect(next("0 0 13 * 5", at(time.January, 12, 1, 0, 0))).To(Equal(at(time.January, 13, 0, 0, 0)))
+
+		// a stepped wildcard is a restriction
+		Expect(next("0 0 */10 * 5", start)).To(Equal(at(time.January, 5, 0, 0, 0)))
+	})
+
+	It("supports a seconds field", func() {
+		Expect(next("*/10 * * * * *", start)).To(Equal(at(time.January, 1, 12, 35, 0)))
+		Expect(next("58 34 12 * * *", start)).To(Equal(at(time.January, 1, 12, 34, 58)))
+		Expect(next("56 34 12 * * *", start)).To(Equal(at(time.January, 2, 12, 34, 56))) // strictly after
+	})
+
+	It("supports descriptors and intervals", func() {
+		Expect(next("@hourly", start)).To(Equal(at(time.January, 1, 13, 0, 0)))
+		Expect(next("@daily", start)).To(Equal(at(time.January, 2, 0, 0, 0)))
+		Expect(next("@midnight", start)).To(Equal(at(time.January, 2, 0, 0, 0)))
+		Expect(next("@weekly", start)).To(Equal(at(time.January, 7, 0, 0, 0)))
+		Expect(next("@monthly", start)).To(Equal(at(time.February, 1, 0, 0, 0)))
+		Expect(next("@yearly", start)).To(Equal(time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(next("@every 1h30m", start)).To(Equal(start.Add(90 * time.Minute)))
+	})
+
+	It("evaluates schedules in a time zone", func() {
+		newYork, err := time.LoadLocation("America/New_York")
+		Expect(err).To(BeNil())
+
+		schedule, err := ParseCronSchedule("0 3 * * *", newYork)
+		Expect(err).To(BeNil())
+		Expect(schedule.Next(start)).To(Equal(time.Date(2018, time.January, 2, 3, 0, 0, 0, newYork)))
+
+		schedule, err = ParseCronSchedule("CRON_TZ=America/New_York 0 3 * * *", time.UTC)
+		Expect(err).To(BeNil())
+		Expect(schedule.Next(start).Equal(time.Date(2018, time.January, 2, 8, 0, 0, 0, time.UTC))).To(BeTrue())
+
+		// across the start of daylight saving time (2:00 -> 3:00 on 2018-03-11), 2:30 doesn't exist that day
+		schedule, err = ParseCronSchedule("30 2 * * *", newYork)
+		Expect(err).To(BeNil())
+		Expect(schedule.Next(time.Date(2018, time.March, 10, 12, 0, 0, 0, newYork))).To(Equal(time.Date(2018, time.March, 12, 2, 30, 0, 0, newYork)))
+
+		// across the end of daylight saving time (2:00 -> 1:00 on 2018-11-04), 1:30 runs once
+		schedule, err = ParseCronSchedule("30 1 * * *", newYork)
+		Expect(err).To(BeNil())
+		first := schedule.Next(time.Date(2018, time.November, 4, 0, 0, 0, 0, newYork))
+		Expect(first.Hour()).To(Equal(1))
+		Expect(schedule.Next(first).Day()).To(Equal(5))
+	})
+
+	It("returns the zero time for a schedule that never runs", func() {
+		Expect(next("0 0 30 2 *", start).IsZero()).To(BeTrue())
+	})
+})
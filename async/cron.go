@@ -0,0 +1,325 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// OverlapPolicy selects what a Cron does when a job is due while a previous run of the job is still in progress.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip skips the run. This is the default.
+	OverlapSkip OverlapPolicy = iota
+
+	// OverlapQueue defers the run until the previous run(s) have finished; the runs of the job never overlap.
+	OverlapQueue
+
+	// OverlapAllow starts the run concurrently with the previous run(s).
+	OverlapAllow
+)
+
+func (o OverlapPolicy) String() string {
+	switch o {
+	case OverlapSkip:
+		return "OverlapSkip"
+	case OverlapQueue:
+		return "OverlapQueue"
+	case OverlapAllow:
+		return "OverlapAllow"
+	}
+
+	return fmt.Sprintf("OverlapPolicy(%d)", int(o))
+}
+
+// CronJob is a recurring job; see Cron.Add.
+type CronJob struct {
+	Name    string `config:"required"` // unique within the Cron
+	Spec    string `config:"required"` // see ParseCronSchedule
+	Run     func() error
+	Overlap OverlapPolicy
+}
+
+// CronEntry describes a job registered with a Cron; see Cron.Entries.
+type CronEntry struct {
+	Name    string
+	Spec    string
+	Next    time.Time // the next run time
+	Prev    time.Time // the previous run time, or the zero time if the job has not yet been due
+	Running int       // runs in progress
+	Queued  int       // runs deferred by OverlapQueue
+}
+
+// CronOptions configures the optional behavior of a Cron. A nil *CronOptions, as well as the zero value of each
+// field, selects the default behavior.
+type CronOptions struct {
+	// Location is the time zone in which job schedules are evaluated, unless overridden by a job's spec; the default
+	// is time.Local.
+	Location *time.Location
+
+	// OnError, if non-nil, is called with the name of a job and the error returned by one of its runs.
+	OnError func(job string, err error)
+}
+
+// Cron runs recurring jobs, as described by cron expressions (see ParseCronSchedule), on a WorkerPool: when a job is
+// due, its Run func is submitted to the pool (see WorkerPool.Submit). Overlapping runs of a job are handled per the
+// job's OverlapPolicy.
+//
+//	c, err := NewCron(pool, nil)
+//	err = c.Add(CronJob{Name: "cleanup", Spec: "0 3 * * *", Run: cleanup})
+//
+// THREAD-SAFETY: the Cron is thread-safe.
+type Cron struct {
+	pool      *WorkerPool
+	options   CronOptions
+	due       chan interface{} // due jobs, from the scheduler
+	scheduler *Scheduler
+	done      chan struct{} // closed on Stop
+
+	// cancelled on Stop, or once the pool has been abandoned; bounds the wait for a run's result
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	jobs      map[string]*cronJob
+	isStopped bool
+}
+
+type cronJob struct {
+	job      CronJob
+	schedule CronSchedule
+	handle   *ScheduledTask
+	next     time.Time
+	prev     time.Time
+	running  int
+	queued   int
+}
+
+// NewCron creates a Cron that runs jobs on the provided pool, and starts its dispatcher. 'opts' may be nil.
+// NewCron will return an error if the pool is nil.
+func NewCron(pool *WorkerPool, opts *CronOptions) (*Cron, error) {
+
+	if pool == nil {
+		return nil, fmt.Errorf("pool cannot be nil")
+	}
+
+	c := &Cron{
+		pool: pool,
+		due:  make(chan interface{}),
+		done: make(chan struct{}),
+		jobs: make(map[string]*cronJob)}
+
+	if opts != nil {
+		c.options = *opts
+	}
+
+	if c.options.Location == nil {
+		c.options.Location = time.Local
+	}
+
+	c.ctx, c.cancel = context.WithCancel(pool.ctx)
+	c.scheduler, _ = NewScheduler(c.due) // the channel is non-nil
+
+	go c.dispatch()
+
+	return c, nil
+}
+
+// Add registers a job. Add returns an error if the job is invalid (including an invalid spec, or one that never
+// runs), if a job with the same name is already registered, or if the Cron has been stopped.
+func (c *Cron) Add(job CronJob) error {
+
+	if err := config.ValidateConstraints(&job); err != nil {
+		return fmt.Errorf("invalid cron job: %v", err)
+	}
+
+	if job.Run == nil {
+		return fmt.Errorf("invalid cron job %q: Run cannot be nil", job.Name)
+	}
+
+	schedule, err := ParseCronSchedule(job.Spec, c.options.Location)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.isStopped {
+		return fmt.Errorf("tried to add a job after the cron has been stopped")
+	}
+
+	if _, ok := c.jobs[job.Name]; ok {
+		return fmt.Errorf("a cron job named %q already exists", job.Name)
+	}
+
+	j := &cronJob{job: job, schedule: schedule}
+	if err := c.scheduleNext(j, time.Now()); err != nil {
+		return err
+	}
+
+	c.jobs[job.Name] = j
+	return nil
+}
+
+// Remove unregisters a job, returning false if there is no such job. Runs already in progress are not affected, but
+// queued runs are discarded.
+func (c *Cron) Remove(name string) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	j, ok := c.jobs[name]
+	if !ok {
+		return false
+	}
+
+	delete(c.jobs, name)
+	j.handle.Cancel()
+	j.queued = 0
+
+	return true
+}
+
+// Entries returns a description of each registered job, ordered by next run time.
+func (c *Cron) Entries() []CronEntry {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entries := make([]CronEntry, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		entries = append(entries, CronEntry{
+			Name:    j.job.Name,
+			Spec:    j.job.Spec,
+			Next:    j.next,
+			Prev:    j.prev,
+			Running: j.running,
+			Queued:  j.queued})
+	}
+
+	sort.Slice(entries, func(i, k int) bool {
+		if !entries[i].Next.Equal(entries[k].Next) {
+			return entries[i].Next.Before(entries[k].Next)
+		}
+		return entries[i].Name < entries[k].Name
+	})
+
+	return entries
+}
+
+// Stop stops the Cron: no further runs are started, and queued runs are discarded. Runs already in progress are not
+// affected, though they are no longer tracked, nor are their errors reported. Stop is non-blocking.
+func (c *Cron) Stop() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.isStopped {
+		return
+	}
+
+	c.isStopped = true
+	c.scheduler.Stop()
+	close(c.done)
+	c.cancel()
+
+	for _, j := range c.jobs {
+		j.queued = 0
+	}
+}
+
+func (c *Cron) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return fmt.Sprintf("&Cron{jobs:%d}", len(c.jobs))
+}
+
+// scheduleNext schedules the next run of a job after 'after'; the caller must hold the mutex.
+func (c *Cron) scheduleNext(j *cronJob, after time.Time) error {
+
+	next := j.schedule.Next(after)
+	if next.IsZero() {
+		return fmt.Errorf("cron job %q has no future run time", j.job.Name)
+	}
+
+	handle, err := c.scheduler.At(next, j)
+	if err != nil {
+		return err
+	}
+
+	j.next = next
+	j.handle = handle
+
+	return nil
+}
+
+// dispatch runs on its own goroutine, starting the runs of jobs as they become due.
+func (c *Cron) dispatch() {
+	for {
+		select {
+		case due := <-c.due:
+			j := due.(*cronJob)
+			now := time.Now()
+
+			c.mutex.Lock()
+
+			if c.isStopped || c.jobs[j.job.Name] != j {
+				c.mutex.Unlock()
+				continue // removed
+			}
+
+			j.prev = j.next
+
+			switch {
+			case j.running == 0 || j.job.Overlap == OverlapAllow:
+				c.start(j)
+			case j.job.Overlap == OverlapQueue:
+				j.queued++
+			}
+
+			if err := c.scheduleNext(j, now); err != nil {
+				delete(c.jobs, j.job.Name) // the schedule has ended
+			}
+
+			c.mutex.Unlock()
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// start submits a run of a job to the pool; the caller must hold the mutex. The run is no longer tracked once the Cron
+// has been stopped, or the pool abandoned (a run still queued on an abandoned pool may never be received by a worker).
+func (c *Cron) start(j *cronJob) {
+
+	j.running++
+
+	go func() {
+		_, err := c.pool.submit(func(context.Context) (interface{}, error) {
+			return nil, j.job.Run()
+		}, c.ctx.Done()).GetWithContext(c.ctx)
+
+		if err != nil && c.ctx.Err() == nil && c.options.OnError != nil {
+			c.options.OnError(j.job.Name, err)
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		j.running--
+		if j.queued > 0 && !c.isStopped && c.ctx.Err() == nil {
+			j.queued--
+			c.start(j)
+		}
+	}()
+}
@@ -0,0 +1,190 @@
+package async_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cron", func() {
+
+	var tasks chan interface{}
+	var pool *WorkerPool
+	var c *Cron
+
+	BeforeEach(func() {
+		tasks = make(chan interface{})
+
+		var err error
+		pool, err = NewWorkerPool(tasks, func(interface{}) {})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(4)).To(Succeed())
+
+		c, err = NewCron(pool, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		c.Stop()
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	noop := func() error { return nil }
+
+	It("requires a pool", func() {
+		_, err := NewCron(nil, nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("validates jobs", func() {
+		Expect(c.Add(CronJob{Spec: "@hourly", Run: noop})).NotTo(Succeed())
+		Expect(c.Add(CronJob{Name: "job", Run: noop})).NotTo(Succeed())
+		Expect(c.Add(CronJob{Name: "job", Spec: "@hourly"})).NotTo(Succeed())
+		Expect(c.Add(CronJob{Name: "job", Spec: "bogus", Run: noop})).NotTo(Succeed())
+		Expect(c.Add(CronJob{Name: "job", Spec: "0 0 30 2 *", Run: noop})).NotTo(Succeed()) // never runs
+
+		Expect(c.Add(CronJob{Name: "job", Spec: "@hourly", Run: noop})).To(Succeed())
+		Expect(c.Add(CronJob{Name: "job", Spec: "@daily", Run: noop})).NotTo(Succeed()) // duplicate
+	})
+
+	It("runs jobs on the pool when due, and reports their errors", func(done Done) {
+		errs := make(chan string, 10)
+		c.Stop()
+
+		var err error
+		c, err = NewCron(pool, &CronOptions{OnError: func(job string, err error) {
+			errs <- fmt.Sprintf("%s: %v", job, err)
+		}})
+		Expect(err).To(BeNil())
+
+		var runs int32
+		Expect(c.Add(CronJob{Name: "every", Spec: "@every 10ms", Run: func() error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}})).To(Succeed())
+		Expect(c.Add(CronJob{Name: "failing", Spec: "* * * * * *", Run: func() error {
+			return fmt.Errorf("failed")
+		}})).To(Succeed())
+
+		Eventually(func() int32 { return atomic.LoadInt32(&runs) }).Should(BeNumerically(">=", 3))
+		Eventually(errs, 2).Should(Receive(Equal("failing: failed")))
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops tracking a run that is still queued when the pool is abandoned", func(done Done) {
+		c.Stop()
+
+		queued, err := NewWorkerPool(make(chan interface{}, 1), func(interface{}) {}) // no workers
+		Expect(err).To(BeNil())
+
+		c, err = NewCron(queued, nil)
+		Expect(err).To(BeNil())
+
+		Expect(c.Add(CronJob{Name: "job", Spec: "@every 10ms", Run: noop})).To(Succeed())
+		Eventually(func() int { return c.Entries()[0].Running }).Should(Equal(1))
+
+		queued.Abandon()
+		Eventually(func() int { return c.Entries()[0].Running }).Should(Equal(0))
+
+		close(done)
+	}, 3) // timeout
+
+	It("lists the next run times of its jobs", func() {
+		Expect(c.Add(CronJob{Name: "daily", Spec: "@daily", Run: noop})).To(Succeed())
+		Expect(c.Add(CronJob{Name: "hourly", Spec: "@hourly", Run: noop})).To(Succeed())
+		Expect(c.Add(CronJob{Name: "removed", Spec: "@hourly", Run: noop})).To(Succeed())
+
+		Expect(c.Remove("removed")).To(BeTrue())
+		Expect(c.Remove("removed")).To(BeFalse())
+
+		entries := c.Entries()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("hourly"))
+		Expect(entries[0].Next).To(BeTemporally("~", time.Now().Truncate(time.Hour).Add(time.Hour), time.Second))
+		Expect(entries[0].Prev.IsZero()).To(BeTrue())
+		Expect(entries[1].Name).To(Equal("daily"))
+		Expect(entries[1].Spec).To(Equal("@daily"))
+	})
+
+	Describe("overlap policies", func() {
+
+		var release chan struct{}
+		var started int32
+
+		blocking := func() error {
+			atomic.AddInt32(&started, 1)
+			<-release
+			return nil
+		}
+
+		startedCount := func() int32 {
+			return atomic.LoadInt32(&started)
+		}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			atomic.StoreInt32(&started, 0)
+		})
+
+		AfterEach(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		})
+
+		It("skips runs while the job is running", func(done Done) {
+			Expect(c.Add(CronJob{Name: "job", Spec: "@every 10ms", Run: blocking, Overlap: OverlapSkip})).To(Succeed())
+
+			Eventually(startedCount).Should(Equal(int32(1)))
+			Consistently(startedCount, 50*time.Millisecond).Should(Equal(int32(1)))
+			Expect(c.Entries()[0].Running).To(Equal(1))
+			Expect(c.Entries()[0].Queued).To(Equal(0))
+
+			close(done)
+		}, 3) // timeout
+
+		It("queues runs while the job is running", func(done Done) {
+			Expect(c.Add(CronJob{Name: "job", Spec: "@every 10ms", Run: blocking, Overlap: OverlapQueue})).To(Succeed())
+
+			Eventually(func() int { return c.Entries()[0].Queued }).Should(BeNumerically(">=", 2))
+			Expect(startedCount()).To(Equal(int32(1)))
+
+			Expect(c.Remove("job")).To(BeTrue()) // discards the queued runs
+			close(release)
+
+			Consistently(startedCount, 50*time.Millisecond).Should(Equal(int32(1)))
+
+			close(done)
+		}, 3) // timeout
+
+		It("runs queued runs once the job finishes", func(done Done) {
+			Expect(c.Add(CronJob{Name: "job", Spec: "@every 10ms", Run: blocking, Overlap: OverlapQueue})).To(Succeed())
+
+			Eventually(func() int { return c.Entries()[0].Queued }).Should(BeNumerically(">=", 1))
+			close(release)
+
+			Eventually(startedCount).Should(BeNumerically(">=", 2))
+
+			close(done)
+		}, 3) // timeout
+
+		It("allows concurrent runs", func(done Done) {
+			Expect(c.Add(CronJob{Name: "job", Spec: "@every 10ms", Run: blocking, Overlap: OverlapAllow})).To(Succeed())
+
+			Eventually(startedCount).Should(BeNumerically(">=", 3))
+			Expect(c.Entries()[0].Running).To(BeNumerically(">=", 3))
+
+			close(done)
+		}, 3) // timeout
+	})
+})
@@ -0,0 +1,532 @@
+package async
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLetter is the record of a task that has permanently failed (see Options.DeadLetters).
+type DeadLetter struct {
+	ID           uint64 // assigned by the sink
+	Task         interface{}
+	Err          error  // the final error
+	Stack        []byte // the stack of the panicking goroutine, if the task panicked
+	Attempts     int
+	FirstAttempt time.Time
+	LastAttempt  time.Time
+}
+
+func (d *DeadLetter) String() string {
+	return fmt.Sprintf("&DeadLetter{id:%d attempts:%d err:%v}", d.ID, d.Attempts, d.Err)
+}
+
+// DeadLetterSink stores dead letters. Implementations must be thread-safe.
+type DeadLetterSink interface {
+	// Put stores a dead letter, assigning its ID.
+	Put(letter *DeadLetter) error
+
+	// List returns the stored dead letters, in the order they were stored.
+	List() ([]*DeadLetter, error)
+
+	// Remove deletes a stored dead letter. Removing an unknown ID is not an error.
+	Remove(id uint64) error
+}
+
+// newDeadLetter builds the dead letter for a task that has finally failed.
+func newDeadLetter(task interface{}, err error, attempts int, firstAttempt time.Time) *DeadLetter {
+
+	letter := &DeadLetter{
+		Task:         task,
+		Err:          err,
+		Attempts:     attempts,
+		FirstAttempt: firstAttempt,
+		LastAttempt:  time.Now()}
+
+	if panicErr, ok := err.(*PanicError); ok {
+		letter.Stack = panicErr.Stack
+	}
+
+	return letter
+}
+
+// Redrive re-enqueues dead letters from the sink onto the pool's task channel, removing each from the sink once it has
+// been queued. Only the dead letters for which 'filter' returns true are re-driven; 'filter' may be nil, in which case
+// all dead letters are re-driven. Redrive blocks while the task channel is full, until the context is done.
+//
+// Redrive returns the number of dead letters re-driven. An error is returned if the pool has been abandoned or shut
+// down (including while waiting to queue a dead letter), if the task channel has been closed, if the context is done,
+// or if the sink returns an error.
+func (p *WorkerPool) Redrive(ctx context.Context, sink DeadLetterSink, filter func(*DeadLetter) bool) (int, error) {
+
+	letters, err := sink.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list dead letters: %v", err)
+	}
+
+	count := 0
+	for _, letter := range letters {
+
+		if filter != nil && !filter(letter) {
+			continue
+		}
+
+		p.mutex.Lock()
+		isAbandoned, isShutdown := p.isAbandoned, p.isShutdown
+		p.mutex.Unlock()
+
+		if isAbandoned || isShutdown {
+			return count, fmt.Errorf("tried to redrive after pool has been abandoned or shut down")
+		}
+
+		if !p.send(letter.Task, ctx.Done()) {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+			return count, fmt.Errorf("failed to queue dead letter %d: the pool has stopped, or the task channel has been closed", letter.ID)
+		}
+
+		if err := sink.Remove(letter.ID); err != nil {
+			return count, fmt.Errorf("failed to remove dead letter %d: %v", letter.ID, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// MemoryDeadLetterSink is an in-memory DeadLetterSink, optionally bounded in size.
+// THREAD-SAFETY: the MemoryDeadLetterSink is thread-safe.
+type MemoryDeadLetterSink struct {
+	capacity int
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	letters []*DeadLetter
+	nextID  uint64
+}
+
+// NewMemoryDeadLetterSink returns an empty MemoryDeadLetterSink. If 'capacity' is positive, the sink holds at most
+// that many dead letters, discarding the oldest to make room; otherwise the sink is unbounded.
+func NewMemoryDeadLetterSink(capacity int) *MemoryDeadLetterSink {
+	return &MemoryDeadLetterSink{
+		capacity: capacity,
+		mutex:    sync.Mutex{},
+		nextID:   1}
+}
+
+// Put stores a dead letter, assigning its ID.
+func (s *MemoryDeadLetterSink) Put(letter *DeadLetter) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	letter.ID = s.nextID
+	s.nextID++
+
+	if s.capacity > 0 && len(s.letters) >= s.capacity {
+		s.letters[0] = nil
+		s.letters = s.letters[1:]
+	}
+
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+// List returns the stored dead letters, in the order they were stored.
+func (s *MemoryDeadLetterSink) List() ([]*DeadLetter, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	letters := make([]*DeadLetter, len(s.letters))
+	copy(letters, s.letters)
+	return letters, nil
+}
+
+// Remove deletes a stored dead letter.
+func (s *MemoryDeadLetterSink) Remove(id uint64) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, letter := range s.letters {
+		if letter.ID == id {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of stored dead letters.
+func (s *MemoryDeadLetterSink) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.letters)
+}
+
+// TaskCodec serializes tasks, for components that persist them (e.g. FileDeadLetterSink).
+type TaskCodec interface {
+	Encode(task interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// JSONTaskCodec is a TaskCodec that uses encoding/json. Tasks are decoded into the value returned by New, which
+// should return a pointer (e.g. func() interface{} { return &MyTask{} }); if New is nil, tasks are decoded into
+// generic JSON values (map[string]interface{}, float64, etc.).
+type JSONTaskCodec struct {
+	New func() interface{}
+}
+
+// Encode serializes a task as JSON.
+func (c JSONTaskCodec) Encode(task interface{}) ([]byte, error) {
+	return json.Marshal(task)
+}
+
+// Decode deserializes a task from JSON.
+func (c JSONTaskCodec) Decode(data []byte) (interface{}, error) {
+
+	if c.New == nil {
+		var task interface{}
+		err := json.Unmarshal(data, &task)
+		return task, err
+	}
+
+	task := c.New()
+	if err := json.Unmarshal(data, task); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// fileDeadLetterRecord is a line of a FileDeadLetterSink's log.
+type fileDeadLetterRecord struct {
+	Op           string    `json:"op"` // "put" or "remove"
+	ID           uint64    `json:"id"`
+	Task         []byte    `json:"task,omitempty"`
+	Err          string    `json:"err,omitempty"`
+	Stack        []byte    `json:"stack,omitempty"`
+	Attempts     int       `json:"attempts,omitempty"`
+	FirstAttempt time.Time `json:"firstAttempt,omitempty"`
+	LastAttempt  time.Time `json:"lastAttempt,omitempty"`
+}
+
+// FileDeadLetterSink is a DeadLetterSink backed by an append-only log file of JSON lines; tasks are serialized with
+// a TaskCodec. Errors are persisted as their messages only. The log grows with each Put and Remove; use Compact to
+// rewrite it.
+// THREAD-SAFETY: the FileDeadLetterSink is thread-safe.
+type FileDeadLetterSink struct {
+	path  string
+	codec TaskCodec
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	file    *os.File
+	letters map[uint64]*DeadLetter
+	nextID  uint64
+}
+
+// OpenFileDeadLetterSink opens (creating if necessary) a FileDeadLetterSink at the provided path, loading any dead
+// letters already stored there. OpenFileDeadLetterSink returns an error if 'codec' is nil, or if the file cannot be
+// read or decoded.
+func OpenFileDeadLetterSink(path string, codec TaskCodec) (*FileDeadLetterSink, error) {
+
+	if codec == nil {
+		return nil, fmt.Errorf("codec cannot be nil")
+	}
+
+	s := &FileDeadLetterSink{
+		path:    path,
+		codec:   codec,
+		mutex:   sync.Mutex{},
+		letters: make(map[uint64]*DeadLetter),
+		nextID:  1}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %v", err)
+	}
+	s.file = file
+
+	return s, nil
+}
+
+// load replays the log file, if it exists. A torn record at the end of the log (i.e. a final line without its newline,
+// as left by a crash mid-write) is truncated rather than reported; its Put never returned.
+func (s *FileDeadLetterSink) load() error {
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for line := 1; ; line++ {
+
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) == 0 {
+				return nil
+			}
+
+			// a torn write; drop it
+			if truncErr := os.Truncate(s.path, offset); truncErr != nil {
+				return fmt.Errorf("failed to truncate torn record on dead letter file line %d: %v", line, truncErr)
+			}
+			return nil
+
+		} else if err != nil {
+			return fmt.Errorf("failed to read dead letter file: %v", err)
+		}
+
+		offset += int64(len(data))
+
+		var record fileDeadLetterRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to decode dead letter file line %d: %v", line, err)
+		}
+
+		if record.ID >= s.nextID {
+			s.nextID = record.ID + 1
+		}
+
+		switch record.Op {
+		case "put":
+			task, err := s.codec.Decode(record.Task)
+			if err != nil {
+				return fmt.Errorf("failed to decode task on dead letter file line %d: %v", line, err)
+			}
+
+			s.letters[record.ID] = &DeadLetter{
+				ID:           record.ID,
+				Task:         task,
+				Err:          errors.New(record.Err),
+				Stack:        record.Stack,
+				Attempts:     record.Attempts,
+				FirstAttempt: record.FirstAttempt,
+				LastAttempt:  record.LastAttempt}
+
+		case "remove":
+			delete(s.letters, record.ID)
+
+		default:
+			return fmt.Errorf("unknown op \"%s\" on dead letter file line %d", record.Op, line)
+		}
+	}
+}
+
+// encode builds the log record for a put.
+func (s *FileDeadLetterSink) encode(letter *DeadLetter) (*fileDeadLetterRecord, error) {
+
+	task, err := s.codec.Encode(letter.Task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode task: %v", err)
+	}
+
+	record := &fileDeadLetterRecord{
+		Op:           "put",
+		ID:           letter.ID,
+		Task:         task,
+		Stack:        letter.Stack,
+		Attempts:     letter.Attempts,
+		FirstAttempt: letter.FirstAttempt,
+		LastAttempt:  letter.LastAttempt}
+
+	if letter.Err != nil {
+		record.Err = letter.Err.Error()
+	}
+
+	return record, nil
+}
+
+// appendRecord writes a record to the log; the caller must hold the mutex.
+func (s *FileDeadLetterSink) appendRecord(record *fileDeadLetterRecord) error {
+
+	if s.file == nil {
+		return fmt.Errorf("dead letter file has been closed")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Put stores a dead letter, assigning its ID.
+func (s *FileDeadLetterSink) Put(letter *DeadLetter) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	letter.ID = s.nextID
+
+	record, err := s.encode(letter)
+	if err != nil {
+		return err
+	}
+
+	if err := s.appendRecord(record); err != nil {
+		return fmt.Errorf("failed to write dead letter: %v", err)
+	}
+
+	s.nextID++
+	s.letters[letter.ID] = letter
+	return nil
+}
+
+// List returns the stored dead letters, in the order they were stored.
+func (s *FileDeadLetterSink) List() ([]*DeadLetter, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sorted(), nil
+}
+
+// sorted returns the stored dead letters in ID order; the caller must hold the mutex.
+func (s *FileDeadLetterSink) sorted() []*DeadLetter {
+
+	letters := make([]*DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+
+	return letters
+}
+
+// Remove deletes a stored dead letter.
+func (s *FileDeadLetterSink) Remove(id uint64) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.letters[id]; !ok {
+		return nil
+	}
+
+	if err := s.appendRecord(&fileDeadLetterRecord{Op: "remove", ID: id}); err != nil {
+		return fmt.Errorf("failed to write dead letter removal: %v", err)
+	}
+
+	delete(s.letters, id)
+	return nil
+}
+
+// Compact rewrites the log file to contain only the stored dead letters (and, if the most recently stored dead letter
+// has been removed, its removal, so that its ID is not reissued).
+func (s *FileDeadLetterSink) Compact() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("dead letter file has been closed")
+	}
+
+	// here we're using the best practice of writing to a temp and then renaming to the target; the temp file is
+	// created alongside the target, as os.Rename may fail across volumes
+	tempFile, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".compact")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file: %v", err)
+	}
+
+	defer tempFile.Close()           // okay to close more than once
+	defer os.Remove(tempFile.Name()) // okay to delete if not existing (e.g. we successfully renamed)
+
+	records := make([]*fileDeadLetterRecord, 0, len(s.letters)+1)
+	for _, letter := range s.sorted() {
+		record, err := s.encode(letter)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	// persist the highest ID issued, which load recovers from the records
+	if lastID := s.nextID - 1; lastID > 0 && s.letters[lastID] == nil {
+		records = append(records, &fileDeadLetterRecord{Op: "remove", ID: lastID})
+	}
+
+	writer := bufio.NewWriter(tempFile)
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write temporary file: %v", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+
+	tempFile.Close()
+
+	if err := os.Rename(tempFile.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace dead letter file: %v", err)
+	}
+
+	// reopen, as the old handle refers to the replaced file
+	s.file.Close()
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.file = nil
+		return fmt.Errorf("failed to reopen dead letter file: %v", err)
+	}
+
+	return nil
+}
+
+// Close closes the log file; the sink cannot be modified afterwards.
+func (s *FileDeadLetterSink) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,299 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type deadLetterTestTask struct {
+	Name string
+}
+
+var _ = Describe("DeadLetter", func() {
+
+	Describe("with a WorkerPool", func() {
+
+		var sink *MemoryDeadLetterSink
+		var tasks chan interface{}
+		var pool *WorkerPool
+		var shouldFail int32
+
+		BeforeEach(func() {
+			sink = NewMemoryDeadLetterSink(0)
+			tasks = make(chan interface{}, 4)
+			atomic.StoreInt32(&shouldFail, 1)
+
+			var err error
+			pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+				if task == "panic" {
+					panic("boom")
+				}
+				if atomic.LoadInt32(&shouldFail) == 1 {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			}, &Options{
+				PanicPolicy: PanicRestart,
+				Retry:       &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+				DeadLetters: sink})
+			Expect(err).To(BeNil())
+			Expect(pool.Add(1)).To(Succeed())
+		})
+
+		AfterEach(func(done Done) {
+			pool.Abandon()
+			pool.Wait()
+			close(done)
+		}, 3) // timeout
+
+		It("captures tasks that have finally failed", func() {
+			tasks <- "task"
+			tasks <- "panic"
+
+			Eventually(sink.Len).Should(Equal(2))
+
+			letters, err := sink.List()
+			Expect(err).To(BeNil())
+
+			byTask := map[interface{}]*DeadLetter{}
+			for _, letter := range letters {
+				byTask[letter.Task] = letter
+			}
+
+			failed := byTask["task"]
+			Expect(failed.Err).To(MatchError("failed"))
+			Expect(failed.Attempts).To(Equal(2))
+			Expect(failed.FirstAttempt).NotTo(BeZero())
+			Expect(failed.LastAttempt).NotTo(BeTemporally("<", failed.FirstAttempt))
+			Expect(failed.Stack).To(BeEmpty())
+
+			panicked := byTask["panic"]
+			Expect(panicked.Stack).NotTo(BeEmpty())
+		})
+
+		It("re-drives dead letters into the pool", func() {
+			tasks <- "a"
+			tasks <- "b"
+			Eventually(sink.Len).Should(Equal(2))
+
+			atomic.StoreInt32(&shouldFail, 0)
+
+			count, err := pool.Redrive(context.Background(), sink, func(letter *DeadLetter) bool {
+				return letter.Task == "a"
+			})
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(1))
+			Expect(sink.Len()).To(Equal(1))
+
+			Eventually(func() uint64 { return pool.Stats().Completed }).Should(Equal(uint64(1)))
+		})
+
+		It("returns an error when re-driving into an abandoned pool", func() {
+			Expect(sink.Put(&DeadLetter{Task: "a"})).To(Succeed())
+			pool.Abandon()
+
+			_, err := pool.Redrive(context.Background(), sink, nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error, rather than panicking, when re-driving into a closed task channel", func() {
+			Expect(sink.Put(&DeadLetter{Task: "a"})).To(Succeed())
+			close(tasks)
+
+			count, err := pool.Redrive(context.Background(), sink, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(count).To(Equal(0))
+			Expect(sink.Len()).To(Equal(1))
+		})
+	})
+
+	Describe("MemoryDeadLetterSink", func() {
+		It("assigns IDs and supports removal", func() {
+			sink := NewMemoryDeadLetterSink(0)
+			a, b := &DeadLetter{Task: "a"}, &DeadLetter{Task: "b"}
+			Expect(sink.Put(a)).To(Succeed())
+			Expect(sink.Put(b)).To(Succeed())
+			Expect(a.ID).NotTo(Equal(b.ID))
+
+			Expect(sink.Remove(a.ID)).To(Succeed())
+			Expect(sink.Remove(12345)).To(Succeed())
+
+			letters, err := sink.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(Equal([]*DeadLetter{b}))
+		})
+
+		It("discards the oldest dead letters when bounded", func() {
+			sink := NewMemoryDeadLetterSink(2)
+			for _, task := range []string{"a", "b", "c"} {
+				Expect(sink.Put(&DeadLetter{Task: task})).To(Succeed())
+			}
+
+			letters, err := sink.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(2))
+			Expect(letters[0].Task).To(Equal("b"))
+		})
+	})
+
+	Describe("FileDeadLetterSink", func() {
+
+		var dir string
+		var path string
+		var codec TaskCodec
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "dead-letter-test")
+			Expect(err).To(BeNil())
+
+			path = filepath.Join(dir, "dead-letters.log")
+			codec = JSONTaskCodec{New: func() interface{} { return &deadLetterTestTask{} }}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("requires a codec", func() {
+			_, err := OpenFileDeadLetterSink(path, nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("persists dead letters and removals across reopening", func() {
+			sink, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+
+			a := &DeadLetter{Task: &deadLetterTestTask{"a"}, Err: fmt.Errorf("failed a"), Attempts: 3}
+			b := &DeadLetter{Task: &deadLetterTestTask{"b"}, Err: fmt.Errorf("failed b"), Stack: []byte("stack")}
+			Expect(sink.Put(a)).To(Succeed())
+			Expect(sink.Put(b)).To(Succeed())
+			Expect(sink.Remove(a.ID)).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			reopened, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+			defer reopened.Close()
+
+			letters, err := reopened.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(1))
+			Expect(letters[0].ID).To(Equal(b.ID))
+			Expect(letters[0].Task).To(Equal(&deadLetterTestTask{"b"}))
+			Expect(letters[0].Err).To(MatchError("failed b"))
+			Expect(letters[0].Stack).To(Equal([]byte("stack")))
+
+			// IDs continue from the highest seen
+			c := &DeadLetter{Task: &deadLetterTestTask{"c"}}
+			Expect(reopened.Put(c)).To(Succeed())
+			Expect(c.ID).To(BeNumerically(">", b.ID))
+		})
+
+		It("truncates a torn record at the end of the log", func() {
+			sink, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+
+			a := &DeadLetter{Task: &deadLetterTestTask{"a"}}
+			Expect(sink.Put(a)).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			Expect(err).To(BeNil())
+			_, err = file.WriteString(`{"op":"put","id":2,"task":"eyJO`) // a crash mid-write
+			Expect(err).To(BeNil())
+			Expect(file.Close()).To(Succeed())
+
+			reopened, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+
+			letters, err := reopened.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(1))
+			Expect(letters[0].ID).To(Equal(a.ID))
+
+			// the log is intact for further writes
+			Expect(reopened.Put(&DeadLetter{Task: &deadLetterTestTask{"b"}})).To(Succeed())
+			Expect(reopened.Close()).To(Succeed())
+
+			reopened, err = OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+			defer reopened.Close()
+
+			letters, err = reopened.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(2))
+			Expect(letters[1].Task).To(Equal(&deadLetterTestTask{"b"}))
+		})
+
+		It("reports a corrupt record that is not at the end of the log", func() {
+			Expect(ioutil.WriteFile(path, []byte("{\"op\":\n{\"op\":\"remove\",\"id\":1}\n"), 0644)).To(Succeed())
+
+			_, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(MatchError(ContainSubstring("line 1")))
+		})
+
+		It("compacts the log", func() {
+			sink, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+			defer sink.Close()
+
+			for _, name := range []string{"a", "b", "c"} {
+				letter := &DeadLetter{Task: &deadLetterTestTask{name}}
+				Expect(sink.Put(letter)).To(Succeed())
+				if name != "b" {
+					Expect(sink.Remove(letter.ID)).To(Succeed())
+				}
+			}
+
+			before, err := os.Stat(path)
+			Expect(err).To(BeNil())
+
+			Expect(sink.Compact()).To(Succeed())
+
+			after, err := os.Stat(path)
+			Expect(err).To(BeNil())
+			Expect(after.Size()).To(BeNumerically("<", before.Size()))
+
+			// still writable after compaction
+			Expect(sink.Put(&DeadLetter{Task: &deadLetterTestTask{"d"}})).To(Succeed())
+
+			letters, err := sink.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(2))
+		})
+
+		It("does not reissue the ID of a removed dead letter after compaction", func() {
+			sink, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+
+			a, b := &DeadLetter{Task: &deadLetterTestTask{"a"}}, &DeadLetter{Task: &deadLetterTestTask{"b"}}
+			Expect(sink.Put(a)).To(Succeed())
+			Expect(sink.Put(b)).To(Succeed())
+			Expect(sink.Remove(b.ID)).To(Succeed())
+			Expect(sink.Compact()).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			reopened, err := OpenFileDeadLetterSink(path, codec)
+			Expect(err).To(BeNil())
+			defer reopened.Close()
+
+			c := &DeadLetter{Task: &deadLetterTestTask{"c"}}
+			Expect(reopened.Put(c)).To(Succeed())
+			Expect(c.ID).To(BeNumerically(">", b.ID))
+
+			letters, err := reopened.List()
+			Expect(err).To(BeNil())
+			Expect(letters).To(HaveLen(2))
+		})
+	})
+})
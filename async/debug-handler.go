@@ -0,0 +1,52 @@
+package async
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/tabwriter"
+	"time"
+)
+
+// DebugHandler returns an http.Handler that renders the state of the pool's workers (see Inspect) as a plain-text
+// table, or as JSON if the request has the query parameter "format=json". Register it on a debug-only listener, e.g.:
+//
+//	http.Handle("/debug/pool", pool.DebugHandler())
+//
+// Note that task descriptions (see Describer) are rendered as-is.
+func (p *WorkerPool) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+		infos := p.Inspect()
+
+		if request.URL.Query().Get("format") == "json" {
+			writer.Header().Set("Content-Type", "application/json")
+
+			if err := json.NewEncoder(writer).Encode(infos); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		now := time.Now()
+
+		fmt.Fprintf(writer, "%v\n%v\n\n", p, p.Stats())
+
+		table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tSTATE\tUPTIME\tCOMPLETED\tTASK\tRUNNING FOR")
+
+		for _, info := range infos {
+			runningFor := ""
+			if !info.TaskStarted.IsZero() {
+				runningFor = now.Sub(info.TaskStarted).Round(time.Millisecond).String()
+			}
+
+			fmt.Fprintf(table, "%d\t%v\t%v\t%d\t%s\t%s\n",
+				info.ID, info.State, now.Sub(info.Started).Round(time.Second), info.Completed, info.Task, runningFor)
+		}
+
+		table.Flush()
+	})
+}
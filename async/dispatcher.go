@@ -0,0 +1,344 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// admission is the policy by which a dispatcher admits its queued tasks to the pool, e.g. one task per key at a time
+// (see KeyedDispatcher). Tasks are queued under a string, e.g. their key or class. The dispatcher calls the methods of
+// its admission with its mutex held.
+type admission interface {
+	// push queues a task; an error rejects the task.
+	push(name string, task interface{}) error
+
+	// next removes and returns the next task that may run, along with a tag that is passed to release once the task
+	// is done. ok is false if no queued task may run at present.
+	next() (task interface{}, tag interface{}, ok bool)
+
+	// release records that a task returned by next is done.
+	release(tag interface{})
+
+	// len returns the number of queued tasks.
+	len() int
+
+	// discard drops the queued tasks.
+	discard()
+}
+
+// dispatchedTask is the envelope that carries a task through the pool on behalf of a dispatcher.
+type dispatchedTask struct {
+	dispatcher *dispatcher
+	task       interface{}
+	tag        interface{} // see admission.next
+}
+
+func (t *dispatchedTask) unwrap() interface{} {
+	return t.task
+}
+
+func (t *dispatchedTask) handle(ctx context.Context) error {
+	return t.dispatcher.run(ctx, t)
+}
+
+func (t *dispatchedTask) done(error) {
+	t.dispatcher.release(t)
+}
+
+// dispatcher holds tasks pushed to it in queues, and passes them to a WorkerPool as its admission permits; it is the
+// machinery shared by the KeyedDispatcher and the Bulkhead. The dispatcher either owns its pool, which it creates (and
+// whose task channel it closes once drained), or shares a pool that belongs to the caller, in which case the
+// dispatcher's tasks are interleaved with the pool's other tasks.
+// THREAD-SAFETY: the dispatcher is thread-safe.
+type dispatcher struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	nameOf     func(task interface{}) string
+	handleTask ContextHandler
+	pool       *WorkerPool
+	isOwner    bool          // the dispatcher owns the pool
+	notify     chan struct{} // signals the dispatch loop that the queues have changed (buffered, size 1)
+	finished   chan struct{} // closed once the dispatch loop has stopped
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	admission   admission
+	running     map[*dispatchedTask]context.CancelFunc // dispatched tasks that are not yet done; non-nil once started
+	isClosed    bool
+	isAbandoned bool // no further tasks are dispatched
+	isCancelled bool // the running tasks have been cancelled, and no further tasks are run
+}
+
+// newOwningDispatcher creates a dispatcher, and a pool of 'workers' workers (configured by 'opts') that it owns, and
+// starts dispatching.
+func newOwningDispatcher(ctx context.Context, workers int, opts *Options, nameOf func(task interface{}) string, handleTask ContextHandler, admission admission) (*dispatcher, error) {
+
+	d := newDispatcher(ctx, nameOf, handleTask, admission)
+
+	pool, err := newOwnedPool(d.ctx, make(chan interface{}), workers, opts)
+	if err != nil {
+		d.cancel()
+		return nil, err
+	}
+
+	d.pool = pool
+	d.isOwner = true
+	d.start()
+
+	return d, nil
+}
+
+// newSharingDispatcher creates a dispatcher that passes its tasks to the provided pool, and starts dispatching.
+func newSharingDispatcher(ctx context.Context, pool *WorkerPool, nameOf func(task interface{}) string, handleTask ContextHandler, admission admission) *dispatcher {
+
+	d := newDispatcher(ctx, nameOf, handleTask, admission)
+	d.pool = pool
+	d.start()
+
+	return d
+}
+
+func newDispatcher(ctx context.Context, nameOf func(task interface{}) string, handleTask ContextHandler, admission admission) *dispatcher {
+
+	d := &dispatcher{
+		nameOf:     nameOf,
+		handleTask: handleTask,
+		notify:     make(chan struct{}, 1),
+		finished:   make(chan struct{}),
+		mutex:      sync.Mutex{},
+		admission:  admission,
+		running:    make(map[*dispatchedTask]context.CancelFunc)}
+
+	d.ctx, d.cancel = context.WithCancel(ctx)
+
+	return d
+}
+
+func (d *dispatcher) start() {
+	go d.dispatch()
+	go d.watch()
+}
+
+// watch runs on its own goroutine, stopping the dispatcher once its context is done, or the pool has stopped (a shared
+// pool may be abandoned or shut down by its owner).
+func (d *dispatcher) watch() {
+	select {
+	case <-d.ctx.Done():
+		d.abandon()
+	case <-d.pool.ctx.Done():
+		d.abandon()
+	case <-d.pool.drain:
+		d.stop(false) // let the running tasks finish as the pool drains
+		<-d.finished
+		d.cancel()
+	case <-d.finished:
+		d.cancel() // release the context
+	}
+}
+
+// newOwnedPool creates a pool of 'workers' workers consuming 'tasks', for a type that carries its tasks through a pool
+// of its own; the tasks must be carriers, as the pool's handler does nothing.
+func newOwnedPool(ctx context.Context, tasks chan interface{}, workers int, opts *Options) (*WorkerPool, error) {
+
+	pool, err := newWorkerPool(ctx, tasks, func(context.Context, interface{}) error {
+		return nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Add(workers); err != nil {
+		pool.Abandon()
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// abandonOnDone calls 'abandon' once 'ctx' is done, unless 'finished' is closed first, in which case 'cancel' is called
+// to release the context.
+func abandonOnDone(ctx context.Context, cancel context.CancelFunc, finished <-chan struct{}, abandon func()) {
+	select {
+	case <-ctx.Done():
+		abandon()
+	case <-finished:
+		cancel()
+	}
+}
+
+// push queues a task according to the admission.
+func (d *dispatcher) push(task interface{}) error {
+
+	name := d.nameOf(task)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.isClosed || d.isAbandoned {
+		return fmt.Errorf("tried to push a task after the dispatcher has been closed")
+	}
+
+	if err := d.admission.push(name, task); err != nil {
+		return err
+	}
+
+	d.signal()
+	return nil
+}
+
+// run runs a dispatched task, with a context that is additionally cancelled if the dispatcher is abandoned (the pool's
+// context covers this for an owned pool, but not for a shared one). A task received after the dispatcher has been
+// abandoned is not run.
+func (d *dispatcher) run(ctx context.Context, t *dispatchedTask) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.mutex.Lock()
+	if d.isCancelled {
+		d.mutex.Unlock()
+		return context.Canceled
+	}
+	d.running[t] = cancel
+	d.mutex.Unlock()
+
+	return d.handleTask(ctx, t.task)
+}
+
+// release records that a dispatched task is done.
+func (d *dispatcher) release(t *dispatchedTask) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.running, t)
+
+	if !d.isAbandoned {
+		d.admission.release(t.tag)
+		d.signal()
+	}
+}
+
+// signal wakes the dispatch loop; the caller must hold the mutex.
+func (d *dispatcher) signal() {
+	select {
+	case d.notify <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// dispatch runs on its own goroutine, passing each task to the pool once the admission permits it.
+func (d *dispatcher) dispatch() {
+
+	defer close(d.finished)
+
+	if d.isOwner {
+		defer close(d.pool.tasks) // drains the pool
+	}
+
+	for {
+		d.mutex.Lock()
+
+		if d.isAbandoned || (d.isClosed && len(d.running) == 0 && d.admission.len() == 0) {
+			d.mutex.Unlock()
+			return
+		}
+
+		task, tag, ok := d.admission.next()
+		if !ok {
+			d.mutex.Unlock()
+			<-d.notify
+			continue
+		}
+
+		t := &dispatchedTask{dispatcher: d, task: task, tag: tag}
+		d.running[t] = nil
+
+		d.mutex.Unlock()
+
+		if !d.pool.send(t, d.ctx.Done()) {
+			d.mutex.Lock()
+			delete(d.running, t)
+			d.mutex.Unlock()
+
+			if d.ctx.Err() != nil || d.pool.ctx.Err() != nil {
+				d.abandon() // abandoned, or the pool has been abandoned
+			} else {
+				d.stop(false) // the pool is draining (see watch), or its task channel has been closed
+			}
+			return
+		}
+	}
+}
+
+// len returns the number of queued tasks (not including running tasks).
+func (d *dispatcher) len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.admission.len()
+}
+
+// withLock calls 'fn' with the mutex held, e.g. to inspect the admission.
+func (d *dispatcher) withLock(fn func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fn()
+}
+
+// close stops the dispatcher from accepting further tasks; the dispatcher stops once the remaining tasks are done.
+func (d *dispatcher) close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.isClosed = true
+	d.signal()
+}
+
+// abandon stops the dispatcher from accepting further tasks, discards any queued tasks, and cancels the context passed
+// to the running tasks; an owned pool is abandoned.
+func (d *dispatcher) abandon() {
+	d.stop(true)
+}
+
+// stop stops the dispatcher from accepting further tasks, and discards any queued tasks; if 'isAbandoning' is set, the
+// context passed to the running tasks is cancelled, and an owned pool is abandoned.
+func (d *dispatcher) stop(isAbandoning bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.isAbandoned {
+		d.isAbandoned = true
+		d.admission.discard()
+		d.signal()
+	}
+
+	if !isAbandoning || d.isCancelled {
+		return
+	}
+
+	d.isCancelled = true
+	for _, cancel := range d.running {
+		if cancel != nil {
+			cancel()
+		}
+	}
+
+	d.cancel()
+
+	if d.isOwner {
+		d.pool.Abandon()
+	}
+}
+
+// wait blocks until the dispatcher has stopped, and the workers of an owned pool have stopped.
+func (d *dispatcher) wait() {
+	<-d.finished
+
+	if d.isOwner {
+		d.pool.Wait()
+	}
+}
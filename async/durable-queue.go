@@ -0,0 +1,714 @@
+package async
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// SyncPolicy selects when a DurableQueue fsyncs its log.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write (push or ack). This is the default.
+	SyncAlways SyncPolicy = iota
+
+	// SyncPeriodically fsyncs at a fixed interval (see DurableQueueConfig.SyncInterval); a crash may lose the writes
+	// made since the last sync.
+	SyncPeriodically
+
+	// SyncNever leaves syncing to the operating system.
+	SyncNever
+)
+
+func (s SyncPolicy) String() string {
+	switch s {
+	case SyncAlways:
+		return "SyncAlways"
+	case SyncPeriodically:
+		return "SyncPeriodically"
+	case SyncNever:
+		return "SyncNever"
+	}
+
+	return fmt.Sprintf("SyncPolicy(%d)", int(s))
+}
+
+// DefaultSegmentSize is the default maximum size of a DurableQueue log segment.
+const DefaultSegmentSize int64 = 64 * 1024 * 1024
+
+// DurableQueueConfig configures a DurableQueue.
+type DurableQueueConfig struct {
+	Dir          string `config:"required"` // directory holding the log segments; created if necessary
+	Codec        TaskCodec
+	SegmentSize  int64 // a new segment is started once the current one reaches this size (default DefaultSegmentSize)
+	Sync         SyncPolicy
+	SyncInterval time.Duration // required for SyncPeriodically
+}
+
+// DurableTask is the envelope in which a DurableQueue dispatches a task; the ID is used to acknowledge the task.
+//
+// A worker acknowledges a DurableTask once it has finally failed (i.e. after any retries, and once it has been reported
+// to Options.OnFailure and Options.DeadLetters), so that it is not replayed; the exception is a task whose error is
+// context.Canceled (e.g. one interrupted by abandoning the pool), which is replayed when the queue is next opened.
+type DurableTask struct {
+	ID   uint64
+	Task interface{}
+	Err  error // non-nil if the persisted task could not be decoded, in which case Task holds the raw payload
+
+	queue *DurableQueue
+}
+
+// done acknowledges a task that has finally failed (see finisher); a task that succeeded is acknowledged by Handler
+// (or by the caller).
+func (t *DurableTask) done(err error) {
+	if err == nil || err == context.Canceled || t.queue == nil {
+		return
+	}
+
+	t.queue.Ack(t.ID) // an error means the task was already acknowledged, or the queue has been closed
+}
+
+func (t *DurableTask) String() string {
+	if t.Err != nil {
+		return fmt.Sprintf("&DurableTask{id:%d err:%v}", t.ID, t.Err)
+	}
+
+	return fmt.Sprintf("&DurableTask{id:%d task:%v}", t.ID, t.Task)
+}
+
+const (
+	durableSegmentSuffix    = ".seg"
+	durableRecordHeaderSize = 1 + 8 + 4 + 4 // type, id, payload length, crc32
+
+	durableRecordEnqueue byte = 1
+	durableRecordAck     byte = 2
+)
+
+// DurableQueue is a persistent task source for a WorkerPool (or Worker): pushed tasks are written to an append-only
+// log of segment files before being dispatched on the channel returned by Tasks(), and are removed from the log only
+// once acknowledged. Tasks that were not acknowledged (e.g. due to a restart) are replayed when the queue is next
+// opened, so delivery is at-least-once.
+//
+// The simplest way to acknowledge tasks is to wrap the pool's handler with Handler, which unwraps each DurableTask and
+// acknowledges it if the handler succeeds:
+//
+//	q, err := OpenDurableQueue(DurableQueueConfig{Dir: dir, Codec: JSONTaskCodec{New: newMyTask}})
+//	pool, err := NewWorkerPoolWithContext(ctx, q.Tasks(), q.Handler(handleTask), nil)
+//
+// THREAD-SAFETY: the DurableQueue is thread-safe.
+type DurableQueue struct {
+	config DurableQueueConfig
+	tasks  chan interface{}
+	notify chan struct{} // signals the dispatcher that there is more to read (buffered, size 1)
+	closed chan struct{} // closed on Close
+	done   sync.WaitGroup
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	isClosed   bool
+	writer     *os.File
+	segments   []uint64          // segment numbers, ascending; the last is the active segment
+	activeSize int64             // committed size of the active segment
+	unacked    map[uint64]int    // per segment: enqueued tasks not yet acknowledged
+	acked      map[uint64]bool   // acknowledged IDs not yet passed by the dispatcher (from replay)
+	inFlight   map[uint64]uint64 // dispatched, unacknowledged IDs -> segment
+	nextID     uint64
+	readSeg    uint64 // the segment the dispatcher is reading
+	dirty      bool   // written since the last sync
+	err        error  // the error that stopped the dispatcher, if any
+}
+
+// OpenDurableQueue opens (creating if necessary) the DurableQueue stored in the configured directory, replays its log,
+// and starts dispatching unacknowledged tasks. OpenDurableQueue returns an error if the config is invalid, or if the
+// log cannot be read (a torn record at the end of the log, as left by a crash, is truncated rather than reported).
+func OpenDurableQueue(cfg DurableQueueConfig) (*DurableQueue, error) {
+
+	if err := config.ValidateConstraints(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid durable queue config: %v", err)
+	}
+
+	if cfg.Codec == nil {
+		return nil, fmt.Errorf("invalid durable queue config: Codec cannot be nil")
+	}
+
+	if cfg.SegmentSize < 0 {
+		return nil, fmt.Errorf("invalid durable queue config: SegmentSize cannot be negative")
+	} else if cfg.SegmentSize == 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+
+	if cfg.Sync == SyncPeriodically && cfg.SyncInterval <= 0 {
+		return nil, fmt.Errorf("invalid durable queue config: SyncInterval must be positive for SyncPeriodically")
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create durable queue directory: %v", err)
+	}
+
+	q := &DurableQueue{
+		config:   cfg,
+		tasks:    make(chan interface{}),
+		notify:   make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		mutex:    sync.Mutex{},
+		unacked:  make(map[uint64]int),
+		acked:    make(map[uint64]bool),
+		inFlight: make(map[uint64]uint64),
+		nextID:   1}
+
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = []uint64{1}
+	}
+
+	active := q.segments[len(q.segments)-1]
+	writer, err := os.OpenFile(q.segmentPath(active), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open durable queue segment: %v", err)
+	}
+
+	info, err := writer.Stat()
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to stat durable queue segment: %v", err)
+	}
+
+	q.writer = writer
+	q.activeSize = info.Size()
+	q.readSeg = q.segments[0]
+
+	q.done.Add(1)
+	go q.dispatch()
+
+	if cfg.Sync == SyncPeriodically {
+		q.done.Add(1)
+		go q.syncPeriodically()
+	}
+
+	return q, nil
+}
+
+func (q *DurableQueue) segmentPath(segment uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d%s", segment, durableSegmentSuffix))
+}
+
+// replay scans the existing segments, establishing the unacknowledged counts, the acknowledged IDs, and the next ID.
+func (q *DurableQueue) replay() error {
+
+	entries, err := ioutil.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read durable queue directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, durableSegmentSuffix) {
+			continue
+		}
+
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, durableSegmentSuffix), 10, 64)
+		if err != nil {
+			continue // not ours
+		}
+
+		q.segments = append(q.segments, segment)
+	}
+
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i] < q.segments[j]
+	})
+
+	enqueued := make(map[uint64]uint64) // unacknowledged ID -> segment
+
+	for i, segment := range q.segments {
+		isLast := i == len(q.segments)-1
+
+		err := q.scanSegment(segment, isLast, func(recordType byte, id uint64, _ []byte) {
+			if id >= q.nextID {
+				q.nextID = id + 1
+			}
+
+			switch recordType {
+			case durableRecordEnqueue:
+				enqueued[id] = segment
+				q.unacked[segment]++
+
+			case durableRecordAck:
+				if enqueuedSegment, ok := enqueued[id]; ok {
+					delete(enqueued, id)
+					q.unacked[enqueuedSegment]--
+					q.acked[id] = true
+				}
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanSegment calls 'visit' for each record of a segment. A torn record at the end of the last segment is truncated.
+func (q *DurableQueue) scanSegment(segment uint64, isLast bool, visit func(recordType byte, id uint64, payload []byte)) error {
+
+	path := q.segmentPath(segment)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open durable queue segment: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		recordType, id, payload, size, err := readDurableRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			if isLast && (err == io.ErrUnexpectedEOF || err == errDurableChecksum) {
+				// a torn write (e.g. a crash mid-append); drop it
+				if truncErr := os.Truncate(path, offset); truncErr != nil {
+					return fmt.Errorf("failed to truncate torn record in durable queue segment %d: %v", segment, truncErr)
+				}
+				return nil
+			}
+
+			return fmt.Errorf("failed to read durable queue segment %d at offset %d: %v", segment, offset, err)
+		}
+
+		visit(recordType, id, payload)
+		offset += size
+	}
+}
+
+var errDurableChecksum = fmt.Errorf("record checksum mismatch")
+
+// readDurableRecord reads a single record, returning its type, ID, payload, and total size. io.EOF is returned only if
+// there are no bytes remaining.
+func readDurableRecord(reader io.Reader) (byte, uint64, []byte, int64, error) {
+
+	var header [durableRecordHeaderSize]byte
+	if n, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF && n == 0 {
+			return 0, 0, nil, 0, io.EOF
+		}
+		return 0, 0, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	recordType := header[0]
+	id := binary.BigEndian.Uint64(header[1:9])
+	length := binary.BigEndian.Uint32(header[9:13])
+	checksum := binary.BigEndian.Uint32(header[13:17])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, 0, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	if durableChecksum(header[:13], payload) != checksum {
+		return 0, 0, nil, 0, errDurableChecksum
+	}
+
+	if recordType != durableRecordEnqueue && recordType != durableRecordAck {
+		return 0, 0, nil, 0, fmt.Errorf("unknown record type %d", recordType)
+	}
+
+	return recordType, id, payload, int64(durableRecordHeaderSize) + int64(length), nil
+}
+
+func durableChecksum(header []byte, payload []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(header)
+	return crc32.Update(checksum, crc32.IEEETable, payload)
+}
+
+func encodeDurableRecord(recordType byte, id uint64, payload []byte) []byte {
+
+	record := make([]byte, durableRecordHeaderSize+len(payload))
+	record[0] = recordType
+	binary.BigEndian.PutUint64(record[1:9], id)
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[13:17], durableChecksum(record[:13], payload))
+	copy(record[durableRecordHeaderSize:], payload)
+
+	return record
+}
+
+// appendRecord writes a record to the active segment, rolling to a new segment first if the active one is full; the
+// caller must hold the mutex.
+func (q *DurableQueue) appendRecord(record []byte) error {
+
+	if q.activeSize >= q.config.SegmentSize {
+		if err := q.roll(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write durable queue record: %v", err)
+	}
+
+	q.activeSize += int64(len(record))
+	q.dirty = true
+
+	if q.config.Sync == SyncAlways {
+		if err := q.writer.Sync(); err != nil {
+			return fmt.Errorf("failed to sync durable queue segment: %v", err)
+		}
+		q.dirty = false
+	}
+
+	return nil
+}
+
+// roll starts a new active segment; the caller must hold the mutex.
+func (q *DurableQueue) roll() error {
+
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("failed to sync durable queue segment: %v", err)
+	}
+
+	next := q.segments[len(q.segments)-1] + 1
+	writer, err := os.OpenFile(q.segmentPath(next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create durable queue segment: %v", err)
+	}
+
+	q.writer.Close()
+	q.writer = writer
+	q.segments = append(q.segments, next)
+	q.activeSize = 0
+	q.dirty = false
+
+	return nil
+}
+
+// removeSegments deletes the leading segments that have been fully read and acknowledged; the caller must hold the
+// mutex. Only leading segments are removed, so that an ack record is never removed while the enqueue record it
+// acknowledges remains.
+func (q *DurableQueue) removeSegments() {
+	for len(q.segments) > 1 {
+		segment := q.segments[0]
+		if segment >= q.readSeg || q.unacked[segment] > 0 {
+			return
+		}
+
+		if err := os.Remove(q.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return // try again later
+		}
+
+		delete(q.unacked, segment)
+		q.segments = q.segments[1:]
+	}
+}
+
+// signal wakes the dispatcher.
+func (q *DurableQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// Push persists a task (per the sync policy) and queues it for dispatch. An error is returned if the task cannot be
+// encoded or written, or if the queue has been closed.
+func (q *DurableQueue) Push(task interface{}) error {
+
+	payload, err := q.config.Codec.Encode(task)
+	if err != nil {
+		return fmt.Errorf("failed to encode task: %v", err)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.isClosed {
+		return fmt.Errorf("tried to push a task after the queue has been closed")
+	}
+
+	id := q.nextID
+	if err := q.appendRecord(encodeDurableRecord(durableRecordEnqueue, id, payload)); err != nil {
+		return err
+	}
+
+	q.nextID++
+	q.unacked[q.segments[len(q.segments)-1]]++
+	q.signal()
+
+	return nil
+}
+
+// Ack acknowledges a dispatched task, removing it from the queue. Acknowledging an unknown (or already acknowledged)
+// ID returns an error.
+func (q *DurableQueue) Ack(id uint64) error {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.isClosed {
+		return fmt.Errorf("tried to acknowledge a task after the queue has been closed")
+	}
+
+	segment, ok := q.inFlight[id]
+	if !ok {
+		return fmt.Errorf("task %d is not awaiting acknowledgement", id)
+	}
+
+	if err := q.appendRecord(encodeDurableRecord(durableRecordAck, id, nil)); err != nil {
+		return err
+	}
+
+	delete(q.inFlight, id)
+	q.unacked[segment]--
+	q.removeSegments()
+
+	return nil
+}
+
+// Handler wraps a task handler for use with a pool consuming this queue: the wrapper unwraps each DurableTask, calls
+// handleTask with the task, and acknowledges the task if handleTask returns nil (a task that finally fails is
+// acknowledged by the worker; see DurableTask). A task that could not be decoded is not passed to handleTask; instead
+// its decoding error is returned, so that the DurableTask is reported to the pool's Options.OnError (and
+// Options.DeadLetters, once it has finally failed). Other task types are passed through unchanged.
+func (q *DurableQueue) Handler(handleTask ContextHandler) ContextHandler {
+	return func(ctx context.Context, task interface{}) error {
+
+		durableTask, ok := task.(*DurableTask)
+		if !ok {
+			return handleTask(ctx, task)
+		}
+
+		if durableTask.Err != nil {
+			return durableTask.Err
+		}
+
+		if err := handleTask(ctx, durableTask.Task); err != nil {
+			return err
+		}
+
+		return q.Ack(durableTask.ID)
+	}
+}
+
+// Tasks returns the channel on which tasks are dispatched, as *DurableTask; pass it to NewWorkerPool (or NewWorker).
+// The channel is closed when the queue is closed, or if the log cannot be read (see Err).
+// IMPORTANT: do not send on, or close, the returned channel.
+func (q *DurableQueue) Tasks() chan interface{} {
+	return q.tasks
+}
+
+// Len returns the number of unacknowledged tasks (including those dispatched but not yet acknowledged).
+func (q *DurableQueue) Len() int {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	count := 0
+	for _, unacked := range q.unacked {
+		count += unacked
+	}
+
+	return count
+}
+
+// Err returns the error that stopped the queue from dispatching tasks (closing the Tasks() channel) before the queue
+// was closed, e.g. because the log was modified externally; Err returns nil if there is none.
+func (q *DurableQueue) Err() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.err
+}
+
+// Close stops dispatching, closes the Tasks() channel, and syncs and closes the log. Unacknowledged tasks remain in
+// the log, and will be replayed when the queue is next opened; tasks dispatched but not acknowledged before Close
+// can no longer be acknowledged. Close returns an error if the log cannot be synced or closed, or if the log could
+// not be read while dispatching (see Err).
+func (q *DurableQueue) Close() error {
+
+	q.mutex.Lock()
+	if q.isClosed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.isClosed = true
+	close(q.closed)
+	q.mutex.Unlock()
+
+	q.done.Wait() // the dispatcher and syncer
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	syncErr := q.writer.Sync()
+	closeErr := q.writer.Close()
+
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync durable queue segment: %v", syncErr)
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return q.err
+}
+
+func (q *DurableQueue) String() string {
+	return fmt.Sprintf("&DurableQueue{dir:%s len:%d}", q.config.Dir, q.Len())
+}
+
+func (q *DurableQueue) syncPeriodically() {
+
+	defer q.done.Done()
+
+	ticker := time.NewTicker(q.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.mutex.Lock()
+			if q.dirty {
+				if err := q.writer.Sync(); err == nil {
+					q.dirty = false
+				}
+			}
+			q.mutex.Unlock()
+
+		case <-q.closed:
+			return
+		}
+	}
+}
+
+// dispatch runs on its own goroutine, reading the log from the oldest segment and sending each unacknowledged task on
+// the Tasks() channel.
+func (q *DurableQueue) dispatch() {
+
+	defer q.done.Done()
+	defer close(q.tasks)
+
+	var file *os.File
+	var reader *bufio.Reader
+	var offset int64
+
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		q.mutex.Lock()
+		segment := q.readSeg
+		active := q.segments[len(q.segments)-1]
+		limit := q.activeSize // only committed records of the active segment are read
+		q.mutex.Unlock()
+
+		if file == nil {
+			var err error
+			if file, err = os.Open(q.segmentPath(segment)); err != nil {
+				q.fail(fmt.Errorf("failed to open durable queue segment: %v", err))
+				return
+			}
+			reader = bufio.NewReader(file)
+			offset = 0
+		}
+
+		if segment == active && offset >= limit {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.closed:
+				return
+			}
+		}
+
+		recordType, id, payload, size, err := readDurableRecord(reader)
+		if err == io.EOF && segment != active {
+			// finished a non-active segment; move on
+			file.Close()
+			file = nil
+
+			q.mutex.Lock()
+			for _, s := range q.segments {
+				if s > segment {
+					q.readSeg = s
+					break
+				}
+			}
+			q.removeSegments()
+			q.mutex.Unlock()
+			continue
+		}
+
+		if err != nil {
+			// the log was validated on replay; an error here means it was modified externally
+			q.fail(fmt.Errorf("failed to read durable queue segment %d at offset %d: %v", segment, offset, err))
+			return
+		}
+
+		offset += size
+
+		if recordType != durableRecordEnqueue {
+			continue
+		}
+
+		q.mutex.Lock()
+		isAcked := q.acked[id]
+		delete(q.acked, id)
+		if !isAcked {
+			q.inFlight[id] = segment
+		}
+		q.mutex.Unlock()
+
+		if isAcked {
+			continue
+		}
+
+		durableTask := &DurableTask{ID: id, queue: q}
+		if durableTask.Task, err = q.config.Codec.Decode(payload); err != nil {
+			// undecodable; hand over the raw bytes (see Handler) rather than silently dropping the task
+			durableTask.Task = payload
+			durableTask.Err = fmt.Errorf("failed to decode durable task %d: %v", id, err)
+		}
+
+		select {
+		case q.tasks <- durableTask:
+		case <-q.closed:
+			return
+		}
+	}
+}
+
+// fail records the error that stopped the dispatcher (see Err).
+func (q *DurableQueue) fail(err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.err = err
+}
@@ -0,0 +1,324 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// rejectingCodec is a JSONTaskCodec that fails to decode a particular task.
+type rejectingCodec struct {
+	JSONTaskCodec
+	reject string
+}
+
+func (c rejectingCodec) Decode(data []byte) (interface{}, error) {
+	task, err := c.JSONTaskCodec.Decode(data)
+	if err == nil && task == c.reject {
+		return nil, fmt.Errorf("rejected %v", task)
+	}
+	return task, err
+}
+
+var _ = Describe("DurableQueue", func() {
+
+	var dir string
+	var cfg DurableQueueConfig
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "durable-queue-test")
+		Expect(err).To(BeNil())
+
+		cfg = DurableQueueConfig{Dir: dir, Codec: JSONTaskCodec{}}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	receive := func(q *DurableQueue) *DurableTask {
+		var task interface{}
+		Eventually(q.Tasks()).Should(Receive(&task))
+		return task.(*DurableTask)
+	}
+
+	segments := func() []string {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Expect(err).To(BeNil())
+		return matches
+	}
+
+	It("validates its config", func() {
+		_, err := OpenDurableQueue(DurableQueueConfig{Codec: JSONTaskCodec{}})
+		Expect(err).NotTo(BeNil())
+
+		_, err = OpenDurableQueue(DurableQueueConfig{Dir: dir})
+		Expect(err).NotTo(BeNil())
+
+		_, err = OpenDurableQueue(DurableQueueConfig{Dir: dir, Codec: JSONTaskCodec{}, SegmentSize: -1})
+		Expect(err).NotTo(BeNil())
+
+		_, err = OpenDurableQueue(DurableQueueConfig{Dir: dir, Codec: JSONTaskCodec{}, Sync: SyncPeriodically})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("dispatches pushed tasks in order", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer q.Close()
+
+		Expect(q.Push("a")).To(Succeed())
+		Expect(q.Push("b")).To(Succeed())
+		Expect(q.Len()).To(Equal(2))
+
+		first := receive(q)
+		second := receive(q)
+		Expect(first.Task).To(Equal("a"))
+		Expect(second.Task).To(Equal("b"))
+		Expect(second.ID).To(BeNumerically(">", first.ID))
+
+		Expect(q.Ack(first.ID)).To(Succeed())
+		Expect(q.Ack(first.ID)).NotTo(Succeed())
+		Expect(q.Len()).To(Equal(1))
+
+		close(done)
+	}, 3) // timeout
+
+	It("replays unacknowledged tasks when reopened", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		for _, task := range []string{"a", "b", "c"} {
+			Expect(q.Push(task)).To(Succeed())
+		}
+
+		Expect(q.Ack(receive(q).ID)).To(Succeed()) // a
+		receive(q)                                 // b, not acknowledged
+		Expect(q.Close()).To(Succeed())
+		Expect(q.Push("d")).NotTo(Succeed())
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+
+		Expect(reopened.Len()).To(Equal(2))
+		Expect(receive(reopened).Task).To(Equal("b"))
+		Expect(receive(reopened).Task).To(Equal("c"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("truncates a torn record at the end of the log", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		Expect(q.Push("a")).To(Succeed())
+		Expect(q.Close()).To(Succeed())
+
+		file, err := os.OpenFile(segments()[0], os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		_, err = file.Write([]byte{1, 0, 0, 0})
+		Expect(err).To(BeNil())
+		file.Close()
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+
+		Expect(reopened.Push("b")).To(Succeed())
+		Expect(receive(reopened).Task).To(Equal("a"))
+		Expect(receive(reopened).Task).To(Equal("b"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("rolls segments and removes those that are fully acknowledged", func(done Done) {
+		cfg.SegmentSize = 1 // a new segment for every record
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer q.Close()
+
+		for i := 0; i < 4; i++ {
+			Expect(q.Push(i)).To(Succeed())
+		}
+		Expect(len(segments())).To(Equal(4))
+
+		for i := 0; i < 4; i++ {
+			Expect(q.Ack(receive(q).ID)).To(Succeed())
+		}
+
+		Eventually(func() int { return len(segments()) }).Should(BeNumerically("<=", 2))
+		Expect(q.Len()).To(Equal(0))
+
+		close(done)
+	}, 3) // timeout
+
+	It("supports periodic syncing", func(done Done) {
+		cfg.Sync = SyncPeriodically
+		cfg.SyncInterval = time.Millisecond
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		Expect(q.Push("a")).To(Succeed())
+		Expect(q.Close()).To(Succeed())
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+		Expect(receive(reopened).Task).To(Equal("a"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("feeds a WorkerPool, acknowledging tasks once they succeed or finally fail", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		var mutex sync.Mutex
+		var handled []interface{}
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), q.Tasks(), q.Handler(func(_ context.Context, task interface{}) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			handled = append(handled, task)
+			if task == "fail" {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		}), nil)
+		Expect(err).To(BeNil())
+		Expect(pool.Add(2)).To(Succeed())
+
+		Expect(q.Push("ok")).To(Succeed())
+		Expect(q.Push("fail")).To(Succeed())
+
+		Eventually(func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(handled)
+		}).Should(Equal(2))
+		Eventually(q.Len).Should(Equal(0))
+
+		Expect(q.Close()).To(Succeed())
+		pool.Wait() // the closed channel drains the pool
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+		Expect(reopened.Len()).To(Equal(0))
+		Consistently(reopened.Tasks(), 20*time.Millisecond).ShouldNot(Receive())
+
+		close(done)
+	}, 3) // timeout
+
+	It("reports tasks that cannot be decoded, rather than handling them", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		Expect(q.Push("ok")).To(Succeed())
+		Expect(q.Push("corrupt")).To(Succeed())
+		Expect(q.Close()).To(Succeed())
+
+		cfg.Codec = rejectingCodec{reject: "corrupt"}
+		q, err = OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		handled := make(chan interface{}, 2)
+		errs := make(chan error, 2)
+		sink := NewMemoryDeadLetterSink(0)
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), q.Tasks(), q.Handler(func(_ context.Context, task interface{}) error {
+			handled <- task
+			return nil
+		}), &Options{
+			OnError:     func(_ interface{}, err error) { errs <- err },
+			DeadLetters: sink})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		Eventually(handled).Should(Receive(Equal("ok")))
+		Eventually(errs).Should(Receive(MatchError(ContainSubstring("rejected corrupt"))))
+		Eventually(sink.Len).Should(Equal(1))
+		Consistently(handled, 20*time.Millisecond).ShouldNot(Receive())
+
+		letters, err := sink.List()
+		Expect(err).To(BeNil())
+		Expect(letters[0].Task.(*DurableTask).Task).To(Equal([]byte(`"corrupt"`)))
+
+		Eventually(q.Len).Should(Equal(0)) // acknowledged once dead-lettered
+		Expect(q.Close()).To(Succeed())
+		pool.Wait()
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+		Expect(reopened.Len()).To(Equal(0))
+		Consistently(reopened.Tasks(), 20*time.Millisecond).ShouldNot(Receive())
+		Expect(segments()).To(HaveLen(1))
+
+		close(done)
+	}, 3) // timeout
+
+	It("does not acknowledge tasks interrupted by abandoning the pool", func(done Done) {
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		started := make(chan struct{})
+		pool, err := NewWorkerPoolWithContext(context.Background(), q.Tasks(), q.Handler(func(ctx context.Context, _ interface{}) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}), nil)
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		Expect(q.Push("interrupted")).To(Succeed())
+		Eventually(started).Should(BeClosed())
+
+		pool.Abandon()
+		pool.Wait()
+		Expect(q.Len()).To(Equal(1))
+		Expect(q.Close()).To(Succeed())
+
+		reopened, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+		defer reopened.Close()
+		Expect(receive(reopened).Task).To(Equal("interrupted"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("reports a log that cannot be read while dispatching", func(done Done) {
+		cfg.SegmentSize = 1 // a new segment for every record
+		q, err := OpenDurableQueue(cfg)
+		Expect(err).To(BeNil())
+
+		Expect(q.Push("a")).To(Succeed())
+		Expect(q.Push("b")).To(Succeed()) // its segment is not read until "a" has been received
+
+		// corrupt the final byte of "b", as though the log had been modified externally
+		file, err := os.OpenFile(segments()[1], os.O_RDWR, 0)
+		Expect(err).To(BeNil())
+		info, err := file.Stat()
+		Expect(err).To(BeNil())
+		_, err = file.WriteAt([]byte{0}, info.Size()-1)
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(Succeed())
+
+		Expect(receive(q).Task).To(Equal("a"))
+		Eventually(q.Tasks()).Should(BeClosed())
+		Expect(q.Err()).To(HaveOccurred())
+		Expect(q.Close()).NotTo(Succeed())
+
+		close(done)
+	}, 3) // timeout
+})
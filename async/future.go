@@ -0,0 +1,97 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Callable is a unit of work that produces a result; see WorkerPool.Submit.
+type Callable func() (interface{}, error)
+
+// ContextCallable is like Callable, but takes the context of the task; see WorkerPool.SubmitWithContext.
+type ContextCallable func(ctx context.Context) (interface{}, error)
+
+// Future is a handle to the result of a Callable submitted to a WorkerPool. The result becomes available once a
+// worker has run the Callable.
+// THREAD-SAFETY: the Future is thread-safe.
+type Future struct {
+	done chan struct{}
+
+	// written once, prior to closing 'done':
+	value interface{}
+	err   error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// newFailedFuture returns an already-completed Future that carries the provided error.
+func newFailedFuture(err error) *Future {
+	f := newFuture()
+	f.complete(nil, err)
+	return f
+}
+
+// complete stores the result and releases any waiters. complete must only be called once.
+func (f *Future) complete(value interface{}, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel that is closed once the result is available.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get is a blocking call that waits for the result to become available, then returns the value and error produced by
+// the Callable.
+// IMPORTANT: a Callable that is still queued when the pool is abandoned may never be received by a worker, in which
+// case Get will block forever; use GetWithContext if the pool may be abandoned.
+func (f *Future) Get() (interface{}, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// GetWithContext is a blocking call that waits for the result to become available or for the context to be done,
+// whichever comes first. If the context is done first, the context's error is returned (the Callable may still run
+// at a later time).
+func (f *Future) GetWithContext(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// futureTask is the envelope that carries a submitted Callable through the pool's task channel.
+type futureTask struct {
+	call      ContextCallable
+	future    *Future
+	submitted time.Time
+}
+
+// run runs the Callable with the provided context, unless the context is already done, in which case the Future is
+// completed with the context's error. run returns the error the Future was completed with.
+func (t *futureTask) run(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		t.future.complete(nil, err)
+		return err
+	}
+
+	value, err := t.call(ctx)
+	t.future.complete(value, err)
+	return err
+}
+
+// Timestamp returns the time at which the Callable was submitted (see Timestamped).
+func (t *futureTask) Timestamp() time.Time {
+	return t.submitted
+}
+
+func (t *futureTask) String() string {
+	return fmt.Sprintf("&futureTask{call:%p}", t.call)
+}
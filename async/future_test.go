@@ -0,0 +1,106 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Future", func() {
+
+	var tasks chan interface{}
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		tasks = make(chan interface{}, 1)
+
+		var err error
+		pool, err = NewWorkerPool(tasks, func(interface{}) {})
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	Describe("Get", func() {
+		It("returns the value produced by the Callable", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+
+			f := pool.Submit(func() (interface{}, error) {
+				return 42, nil
+			})
+
+			value, err := f.Get()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(42))
+
+			close(done)
+		}, 3) // timeout
+
+		It("returns the error produced by the Callable", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+
+			f := pool.Submit(func() (interface{}, error) {
+				return nil, fmt.Errorf("failed")
+			})
+
+			_, err := f.Get()
+			Expect(err).To(MatchError("failed"))
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	Describe("GetWithContext", func() {
+		It("returns the context's error if the context is done first", func(done Done) {
+			// no workers, so the Callable is never run
+			f := pool.Submit(func() (interface{}, error) {
+				return 42, nil
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := f.GetWithContext(ctx)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+
+			close(done)
+		}, 3) // timeout
+
+		It("returns the result if available before the context is done", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+
+			f := pool.Submit(func() (interface{}, error) {
+				return "result", nil
+			})
+
+			value, err := f.GetWithContext(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("result"))
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	Describe("Done", func() {
+		It("is closed once the result is available", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+
+			f := pool.Submit(func() (interface{}, error) {
+				return nil, nil
+			})
+
+			Eventually(f.Done()).Should(BeClosed())
+
+			close(done)
+		}, 3) // timeout
+	})
+})
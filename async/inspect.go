@@ -0,0 +1,154 @@
+package async
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// WorkerState is the state of a worker, as reported by Inspect.
+type WorkerState int
+
+const (
+	// WorkerIdle is waiting for a task.
+	WorkerIdle WorkerState = iota
+
+	// WorkerBusy is running a task.
+	WorkerBusy
+
+	// WorkerStopping has been told to stop (e.g. it has been removed from its pool, or abandoned), but has not yet
+	// stopped; it may be finishing a task.
+	WorkerStopping
+
+	// WorkerStopped has stopped.
+	WorkerStopped
+)
+
+func (s WorkerState) String() string {
+	switch s {
+	case WorkerIdle:
+		return "idle"
+	case WorkerBusy:
+		return "busy"
+	case WorkerStopping:
+		return "stopping"
+	case WorkerStopped:
+		return "stopped"
+	}
+
+	return fmt.Sprintf("WorkerState(%d)", int(s))
+}
+
+// MarshalText renders the state as its name, e.g. for JSON.
+func (s WorkerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Describer is an optional interface for tasks that describe themselves, e.g. "resize image 1234"; the description is
+// reported by Inspect. Describe should be fast, and must be thread-safe.
+type Describer interface {
+	Describe() string
+}
+
+// describeTask returns a summary of a task: its description if it is a Describer, otherwise its type.
+func describeTask(task interface{}) string {
+
+	if d, ok := task.(Describer); ok {
+		return d.Describe()
+	}
+
+	if _, ok := task.(*futureTask); ok {
+		return "Callable"
+	}
+
+	return fmt.Sprintf("%T", task)
+}
+
+// WorkerInfo is a snapshot of a worker's state; see Worker.Inspect and WorkerPool.Inspect.
+type WorkerInfo struct {
+	ID          uint64
+	State       WorkerState
+	Started     time.Time // when the worker started
+	Task        string    // a summary of the running task (see Describer), or "" if there is none
+	TaskStarted time.Time // when the running task started, or the zero time if there is none
+	Completed   uint64    // the number of tasks the worker has run, whether they succeeded or failed
+}
+
+// Inspect returns a snapshot of the worker's state.
+func (w *Worker) Inspect() WorkerInfo {
+
+	w.mutex.Lock()
+	info := WorkerInfo{
+		ID:          w.id,
+		State:       WorkerIdle,
+		Started:     w.started,
+		TaskStarted: w.taskStarted,
+		Completed:   w.completed}
+	isBusy, task := w.isBusy, w.task
+	w.mutex.Unlock()
+
+	if isBusy {
+		info.State = WorkerBusy
+		info.Task = describeTask(unwrapTask(task))
+	}
+
+	select {
+	case <-w.stopped:
+		info.State = WorkerStopped
+		return info
+	default:
+	}
+
+	select {
+	case <-w.abandon:
+		info.State = WorkerStopping
+	default:
+	}
+
+	return info
+}
+
+// Inspect returns a snapshot of the state of each worker in the pool, ordered by ID, followed by any workers that have
+// been removed from the pool (e.g. by Remove) but have not yet stopped.
+func (p *WorkerPool) Inspect() []WorkerInfo {
+
+	p.mutex.Lock()
+	p.pruneStopping()
+	workers := append([]*Worker(nil), p.workers...)
+	stopping := append([]*Worker(nil), p.stopping...)
+	p.mutex.Unlock()
+
+	infos := make([]WorkerInfo, 0, len(workers)+len(stopping))
+	for _, w := range workers {
+		infos = append(infos, w.Inspect())
+	}
+
+	sort.Slice(infos, func(i, k int) bool {
+		return infos[i].ID < infos[k].ID
+	})
+
+	for _, w := range stopping {
+		infos = append(infos, w.Inspect())
+	}
+
+	return infos
+}
+
+// pruneStopping forgets the removed workers that have stopped; the caller must hold the mutex.
+func (p *WorkerPool) pruneStopping() {
+
+	running := p.stopping[:0]
+	for _, w := range p.stopping {
+		select {
+		case <-w.stopped:
+		default:
+			running = append(running, w)
+		}
+	}
+
+	for i := len(running); i < len(p.stopping); i++ {
+		p.stopping[i] = nil
+	}
+
+	p.stopping = running
+}
@@ -0,0 +1,156 @@
+package async_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type describedTask struct {
+	name string
+}
+
+func (t describedTask) Describe() string {
+	return "described " + t.name
+}
+
+var _ = Describe("Inspect", func() {
+
+	var tasks chan interface{}
+	var release chan struct{}
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		tasks = make(chan interface{})
+		release = make(chan struct{})
+
+		var err error
+		pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+			if task != "quick" {
+				<-release
+			}
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("reports the state of each worker", func(done Done) {
+		Expect(pool.Add(3)).To(Succeed())
+
+		tasks <- "quick"
+		tasks <- describedTask{name: "task"}
+		tasks <- 42
+
+		var infos []WorkerInfo
+		Eventually(func() int {
+			infos = pool.Inspect()
+			busy := 0
+			for _, info := range infos {
+				if info.State == WorkerBusy {
+					busy++
+				}
+			}
+			return busy
+		}).Should(Equal(2))
+
+		Expect(infos).To(HaveLen(3))
+
+		tasksSeen := []string{}
+		completed := uint64(0)
+		for i, info := range infos {
+			Expect(info.ID).NotTo(BeZero())
+			if i > 0 {
+				Expect(info.ID).To(BeNumerically(">", infos[i-1].ID))
+			}
+			Expect(info.Started.IsZero()).To(BeFalse())
+
+			if info.State == WorkerBusy {
+				tasksSeen = append(tasksSeen, info.Task)
+				Expect(info.TaskStarted.IsZero()).To(BeFalse())
+			} else {
+				Expect(info.State).To(Equal(WorkerIdle))
+				Expect(info.Task).To(BeEmpty())
+			}
+			completed += info.Completed
+		}
+
+		Expect(tasksSeen).To(ConsistOf("described task", "int"))
+		Expect(completed).To(BeNumerically(">=", 1))
+
+		close(done)
+	}, 3) // timeout
+
+	It("reports removed workers until they have stopped", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+		tasks <- "blocking"
+		Eventually(func() WorkerState { return pool.Inspect()[0].State }).Should(Equal(WorkerBusy))
+
+		handle, err := pool.Shrink(1)
+		Expect(err).To(BeNil())
+
+		infos := pool.Inspect()
+		Expect(infos).To(HaveLen(1))
+		Expect(infos[0].State).To(Equal(WorkerStopping))
+		Expect(infos[0].Task).To(Equal("string"))
+
+		close(release)
+		Eventually(handle.Done()).Should(BeClosed())
+		Expect(pool.Inspect()).To(BeEmpty())
+
+		close(done)
+	}, 3) // timeout
+
+	It("reports workers that have stopped", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+		close(tasks)
+		pool.Wait()
+
+		Expect(pool.Inspect()[0].State).To(Equal(WorkerStopped))
+
+		close(done)
+	}, 3) // timeout
+
+	Describe("DebugHandler", func() {
+
+		It("renders the workers as a table, or as JSON", func(done Done) {
+			Expect(pool.Add(2)).To(Succeed())
+			tasks <- describedTask{name: "task"}
+			Eventually(func() int { return pool.Stats().Busy }).Should(Equal(1))
+
+			recorder := httptest.NewRecorder()
+			pool.DebugHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/pool", nil))
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(ContainSubstring("STATE"))
+			Expect(recorder.Body.String()).To(ContainSubstring("described task"))
+			Expect(recorder.Body.String()).To(ContainSubstring("idle"))
+
+			recorder = httptest.NewRecorder()
+			pool.DebugHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/pool?format=json", nil))
+			Expect(recorder.Code).To(Equal(200))
+
+			var infos []map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &infos)).To(Succeed())
+			Expect(infos).To(HaveLen(2))
+			Expect([]interface{}{infos[0]["State"], infos[1]["State"]}).To(ConsistOf("busy", "idle"))
+
+			close(done)
+		}, 3) // timeout
+	})
+})
@@ -0,0 +1,172 @@
+package async
+
+import (
+	"context"
+	"fmt"
+)
+
+// keyQueue holds the queued tasks of a key that has a task running (or ready to run).
+type keyQueue struct {
+	key   string
+	tasks []interface{}
+}
+
+// keyedAdmission admits one task of each key at a time, in the order they were pushed (see KeyedDispatcher).
+type keyedAdmission struct {
+	queues map[string]*keyQueue // keys with a running or queued task
+	ready  []*keyQueue          // keys with no running task, and at least one queued task, in FIFO order
+	queued int
+}
+
+func (a *keyedAdmission) push(key string, task interface{}) error {
+
+	q, ok := a.queues[key]
+	if !ok {
+		// an idle key: it's immediately ready
+		q = &keyQueue{key: key}
+		a.queues[key] = q
+		a.ready = append(a.ready, q)
+	}
+
+	q.tasks = append(q.tasks, task)
+	a.queued++
+
+	return nil
+}
+
+func (a *keyedAdmission) next() (interface{}, interface{}, bool) {
+
+	if len(a.ready) == 0 {
+		return nil, nil, false
+	}
+
+	q := a.ready[0]
+	a.ready[0] = nil
+	a.ready = a.ready[1:]
+
+	task := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	a.queued--
+
+	return task, q, true
+}
+
+// release makes the key ready again if it has queued tasks, or evicts it.
+func (a *keyedAdmission) release(tag interface{}) {
+
+	q := tag.(*keyQueue)
+
+	if len(q.tasks) > 0 {
+		a.ready = append(a.ready, q)
+	} else {
+		delete(a.queues, q.key)
+	}
+}
+
+func (a *keyedAdmission) len() int {
+	return a.queued
+}
+
+func (a *keyedAdmission) discard() {
+	a.queues = make(map[string]*keyQueue)
+	a.ready = nil
+	a.queued = 0
+}
+
+// KeyedDispatcher runs tasks on a shared WorkerPool such that tasks with the same key (as determined by a key
+// function, e.g. an account ID) run one at a time, in the order they were pushed, while tasks with different keys run
+// in parallel. Each key with outstanding tasks has its own queue; keys take turns to dispatch a task, so that a key
+// with a large backlog cannot starve the others. A key's queue is evicted as soon as it becomes idle, so memory use is
+// proportional to the number of keys with outstanding tasks, rather than the number of keys ever seen.
+//
+// A task is not done until it has succeeded or finally failed, so a task awaiting a retry (see Options.Retry) holds up
+// the later tasks of its key.
+// THREAD-SAFETY: the KeyedDispatcher is thread-safe.
+type KeyedDispatcher struct {
+	dispatcher *dispatcher
+	admission  *keyedAdmission // covered by the dispatcher's mutex
+}
+
+// NewKeyedDispatcher creates a KeyedDispatcher with 'workers' workers, running handleTask for each task pushed; 'keyOf'
+// returns the key of a task. The options configure the underlying WorkerPool; the options' hooks receive the tasks as
+// pushed.
+// NewKeyedDispatcher will return an error if the provided context, key func, or handler are nil, if 'workers' is not
+// positive, or if the options are invalid.
+func NewKeyedDispatcher(ctx context.Context, workers int, keyOf func(task interface{}) string, handleTask ContextHandler, opts *Options) (*KeyedDispatcher, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("context cannot be nil")
+	}
+
+	if keyOf == nil {
+		return nil, fmt.Errorf("keyOf func cannot be nil")
+	}
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	if workers < 1 {
+		return nil, fmt.Errorf("workers must be positive (%d)", workers)
+	}
+
+	admission := &keyedAdmission{queues: make(map[string]*keyQueue)}
+
+	d, err := newOwningDispatcher(ctx, workers, opts, keyOf, handleTask, admission)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyedDispatcher{
+		dispatcher: d,
+		admission:  admission}, nil
+}
+
+// Push queues a task behind any outstanding tasks with the same key. Push does not block.
+// An error is returned on an attempt to push to a closed or abandoned dispatcher.
+func (d *KeyedDispatcher) Push(task interface{}) error {
+	return d.dispatcher.push(task)
+}
+
+// Len returns the number of queued tasks (not including running tasks).
+func (d *KeyedDispatcher) Len() int {
+	return d.dispatcher.len()
+}
+
+// Keys returns the number of keys with outstanding (running or queued) tasks.
+func (d *KeyedDispatcher) Keys() int {
+	var keys int
+	d.dispatcher.withLock(func() {
+		keys = len(d.admission.queues)
+	})
+
+	return keys
+}
+
+// Close stops the dispatcher from accepting further tasks; the remaining tasks will be run, then the workers will
+// stop. Close is non-blocking; use Wait to wait for the workers to stop.
+func (d *KeyedDispatcher) Close() {
+	d.dispatcher.close()
+}
+
+// Abandon stops the dispatcher from accepting further tasks, discards any queued tasks, and abandons the workers (see
+// WorkerPool.Abandon). Abandon is non-blocking; use Wait to wait for the workers to stop.
+func (d *KeyedDispatcher) Abandon() {
+	d.dispatcher.abandon()
+}
+
+// Wait blocks until the dispatcher has stopped (having been closed and drained, or abandoned), and its workers have
+// stopped.
+func (d *KeyedDispatcher) Wait() {
+	d.dispatcher.wait()
+}
+
+func (d *KeyedDispatcher) String() string {
+	var keys, queued int
+	d.dispatcher.withLock(func() {
+		keys, queued = len(d.admission.queues), d.admission.queued
+	})
+
+	return fmt.Sprintf("&KeyedDispatcher{keys:%d queued:%d}", keys, queued)
+}
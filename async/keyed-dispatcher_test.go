@@ -0,0 +1,176 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type keyedTestTask struct {
+	Key string
+	Seq int
+}
+
+var _ = Describe("KeyedDispatcher", func() {
+
+	keyOf := func(task interface{}) string {
+		return task.(*keyedTestTask).Key
+	}
+
+	It("validates its arguments", func() {
+		handler := func(context.Context, interface{}) error { return nil }
+
+		_, err := NewKeyedDispatcher(nil, 1, keyOf, handler, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewKeyedDispatcher(context.Background(), 0, keyOf, handler, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewKeyedDispatcher(context.Background(), 1, nil, handler, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewKeyedDispatcher(context.Background(), 1, keyOf, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewKeyedDispatcher(context.Background(), 1, keyOf, handler, &Options{Retry: &RetryPolicy{}})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("runs the tasks of a key serially and in order, and different keys in parallel", func(done Done) {
+		var mutex sync.Mutex
+		running := map[string]int{}
+		order := map[string][]int{}
+		maxRunning := 0
+		overlapped := false
+
+		d, err := NewKeyedDispatcher(context.Background(), 4, keyOf, func(_ context.Context, task interface{}) error {
+			t := task.(*keyedTestTask)
+
+			mutex.Lock()
+			running[t.Key]++
+			if running[t.Key] > 1 {
+				overlapped = true
+			}
+			total := 0
+			for _, count := range running {
+				total += count
+			}
+			if total > maxRunning {
+				maxRunning = total
+			}
+			order[t.Key] = append(order[t.Key], t.Seq)
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			running[t.Key]--
+			mutex.Unlock()
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+
+		keys := []string{"a", "b", "c", "d"}
+		for seq := 0; seq < 10; seq++ {
+			for _, key := range keys {
+				Expect(d.Push(&keyedTestTask{Key: key, Seq: seq})).To(Succeed())
+			}
+		}
+
+		d.Close()
+		Expect(d.Push(&keyedTestTask{Key: "a"})).NotTo(Succeed())
+		d.Wait()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		Expect(overlapped).To(BeFalse())
+		Expect(maxRunning).To(BeNumerically(">", 1))
+		for _, key := range keys {
+			Expect(order[key]).To(Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+		}
+
+		Expect(d.Len()).To(Equal(0))
+		Expect(d.Keys()).To(Equal(0)) // idle keys are evicted
+
+		close(done)
+	}, 3) // timeout
+
+	It("holds a key while its task awaits a retry, and passes the tasks themselves to the options' hooks", func(done Done) {
+		var mutex sync.Mutex
+		var order []int
+		attempts := 0
+		failures := make(chan interface{}, 1)
+
+		d, err := NewKeyedDispatcher(context.Background(), 2, keyOf, func(_ context.Context, task interface{}) error {
+			t := task.(*keyedTestTask)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if t.Seq == 0 {
+				if attempts++; attempts < 3 {
+					return fmt.Errorf("flaky")
+				}
+			}
+			if t.Seq == 2 {
+				return fmt.Errorf("failed")
+			}
+
+			order = append(order, t.Seq)
+			return nil
+		}, &Options{
+			Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond},
+			OnFailure: func(task interface{}, _ error, _ int) {
+				failures <- task
+			}})
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 4; seq++ {
+			Expect(d.Push(&keyedTestTask{Key: "a", Seq: seq})).To(Succeed())
+		}
+
+		d.Close()
+		d.Wait()
+
+		Expect(order).To(Equal([]int{0, 1, 3}))
+		Expect(failures).To(Receive(Equal(&keyedTestTask{Key: "a", Seq: 2})))
+
+		close(done)
+	}, 3) // timeout
+
+	It("discards queued tasks when abandoned, or when its context is cancelled", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var ran int32
+
+		d, err := NewKeyedDispatcher(ctx, 1, keyOf, func(ctx context.Context, _ interface{}) error {
+			atomic.AddInt32(&ran, 1)
+			<-ctx.Done()
+			return ctx.Err()
+		}, nil)
+		Expect(err).To(BeNil())
+
+		for seq := 0; seq < 5; seq++ {
+			Expect(d.Push(&keyedTestTask{Key: "a", Seq: seq})).To(Succeed())
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&ran) }).Should(Equal(int32(1)))
+		Expect(d.Len()).To(Equal(4))
+
+		cancel()
+		d.Wait()
+
+		Expect(atomic.LoadInt32(&ran)).To(Equal(int32(1)))
+		Expect(d.Len()).To(Equal(0))
+		Expect(d.Push(&keyedTestTask{Key: "a"})).NotTo(Succeed())
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,54 @@
+package async
+
+import (
+	"context"
+	"fmt"
+)
+
+// workerValueKey is the context key of the per-worker value.
+type workerValueKey struct{}
+
+// WorkerValue returns the per-worker value (see Options.OnWorkerStart) of the worker running a task, given the context
+// passed to the task's handler (see ContextHandler). WorkerValue returns nil if there is none, e.g. if the worker was
+// created without an OnWorkerStart hook (which requires a constructor that takes Options, such as
+// NewWorkerWithContext).
+//
+// The context is the only way to reach the value; a handler that doesn't receive one (e.g. one passed to NewWorker)
+// cannot use it.
+func WorkerValue(ctx context.Context) interface{} {
+	return ctx.Value(workerValueKey{})
+}
+
+// startHook calls the OnWorkerStart hook, if any, on the worker goroutine. startHook returns false if the worker failed
+// to start.
+func (w *Worker) startHook() bool {
+
+	if w.options.OnWorkerStart == nil {
+		return true
+	}
+
+	value, err := w.options.OnWorkerStart(w.id)
+	if err != nil {
+		if w.options.OnError != nil {
+			w.options.OnError(nil, fmt.Errorf("worker %d failed to start: %v", w.id, err))
+		}
+
+		if w.pool != nil {
+			w.pool.detach(w)
+		}
+
+		return false
+	}
+
+	w.value = value
+	w.taskCtx = context.WithValue(w.ctx, workerValueKey{}, value)
+
+	return true
+}
+
+// stopHook calls the OnWorkerStop hook, if any, on the worker goroutine.
+func (w *Worker) stopHook() {
+	if w.options.OnWorkerStop != nil {
+		w.options.OnWorkerStop(w.id, w.value)
+	}
+}
@@ -0,0 +1,157 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type workerResource struct {
+	workerID uint64
+	isClosed bool
+}
+
+var _ = Describe("Worker lifecycle hooks", func() {
+
+	var mutex sync.Mutex
+	var started map[uint64]*workerResource
+	var stopped map[uint64]*workerResource
+	var opts *Options
+
+	BeforeEach(func() {
+		started = map[uint64]*workerResource{}
+		stopped = map[uint64]*workerResource{}
+
+		opts = &Options{
+			OnWorkerStart: func(workerID uint64) (interface{}, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				resource := &workerResource{workerID: workerID}
+				started[workerID] = resource
+				return resource, nil
+			},
+			OnWorkerStop: func(workerID uint64, value interface{}) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				resource := value.(*workerResource)
+				resource.isClosed = true
+				stopped[workerID] = resource
+			}}
+	})
+
+	counts := func() (int, int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return len(started), len(stopped)
+	}
+
+	It("passes each worker's value to its tasks, as the pool grows and shrinks", func(done Done) {
+		tasks := make(chan interface{})
+		seen := make(chan *workerResource, 10)
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(ctx context.Context, _ interface{}) error {
+			seen <- WorkerValue(ctx).(*workerResource)
+			return nil
+		}, opts)
+		Expect(err).To(BeNil())
+
+		Expect(pool.Add(2)).To(Succeed())
+		Eventually(func() int { s, _ := counts(); return s }).Should(Equal(2))
+
+		handle, err := pool.Shrink(1)
+		Expect(err).To(BeNil())
+		Expect(handle.Wait(context.Background())).To(Succeed())
+
+		_, stopCount := counts()
+		Expect(stopCount).To(Equal(1))
+
+		Expect(pool.Add(1)).To(Succeed())
+
+		for i := 0; i < 5; i++ {
+			tasks <- i
+			var resource *workerResource
+			Eventually(seen).Should(Receive(&resource))
+
+			mutex.Lock()
+			Expect(started[resource.workerID]).To(BeIdenticalTo(resource))
+			Expect(resource.isClosed).To(BeFalse())
+			mutex.Unlock()
+		}
+
+		close(tasks)
+		pool.Wait()
+
+		startCount, stopCount := counts()
+		Expect(startCount).To(Equal(3))
+		Expect(stopCount).To(Equal(3))
+		for id, resource := range started {
+			Expect(stopped[id]).To(BeIdenticalTo(resource))
+		}
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops a worker whose start hook fails", func(done Done) {
+		tasks := make(chan interface{})
+		errs := make(chan error, 10)
+
+		opts.OnWorkerStart = func(uint64) (interface{}, error) {
+			return nil, fmt.Errorf("no connection")
+		}
+		opts.OnError = func(task interface{}, err error) {
+			if task == nil {
+				errs <- err
+			}
+		}
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(context.Context, interface{}) error {
+			return nil
+		}, opts)
+		Expect(err).To(BeNil())
+
+		Expect(pool.Add(2)).To(Succeed())
+		Eventually(errs).Should(Receive(MatchError(ContainSubstring("no connection"))))
+		Eventually(pool.Size).Should(Equal(0))
+		pool.Wait()
+
+		_, stopCount := counts()
+		Expect(stopCount).To(Equal(0))
+
+		close(done)
+	}, 3) // timeout
+
+	It("supports standalone workers", func(done Done) {
+		tasks := make(chan interface{})
+		seen := make(chan interface{}, 1)
+
+		w, err := NewWorkerWithContext(context.Background(), tasks, func(ctx context.Context, _ interface{}) error {
+			seen <- WorkerValue(ctx)
+			return nil
+		}, nil, opts)
+		Expect(err).To(BeNil())
+
+		tasks <- 1
+		Eventually(seen).Should(Receive(Equal(&workerResource{workerID: w.ID()})))
+
+		w.Abandon()
+		w.Wait()
+
+		mutex.Lock()
+		Expect(stopped[w.ID()].isClosed).To(BeTrue())
+		mutex.Unlock()
+
+		close(done)
+	}, 3) // timeout
+
+	It("has no worker value without hooks", func() {
+		Expect(WorkerValue(context.Background())).To(BeNil())
+	})
+})
@@ -0,0 +1,92 @@
+package async
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options configures the optional behavior of Workers and WorkerPools. A nil *Options, as well as the zero value of
+// each field, selects the default behavior.
+type Options struct {
+	// OnError, if non-nil, is called (on the worker goroutine) with each task whose handler returned a non-nil error,
+	// and with each task that was skipped because its context was already done. Recovered panics are reported as a
+	// *PanicError.
+	OnError func(task interface{}, err error)
+
+	// Retry, if non-nil, causes tasks that fail with a retryable error to be re-enqueued for another attempt; see
+	// RetryPolicy. Callables submitted via WorkerPool.Submit are not retried.
+	Retry *RetryPolicy
+
+	// OnFailure, if non-nil, is called (on the worker goroutine) with each task that has finally failed -- i.e. that
+	// will not be retried -- along with its final error and the number of attempts made. Callables submitted via
+	// WorkerPool.Submit are excluded (their errors are delivered via their Future).
+	OnFailure func(task interface{}, err error, attempts int)
+
+	// DeadLetters, if non-nil, receives a DeadLetter for each task that has finally failed (as for OnFailure); see
+	// WorkerPool.Redrive. Errors from the sink are reported to OnError.
+	DeadLetters DeadLetterSink
+
+	// PanicPolicy selects how a worker handles a panic raised while running a task; see PanicPolicy.
+	PanicPolicy PanicPolicy
+
+	// Limiter, if non-nil, is waited upon by the worker before each task is run; share a Limiter (e.g. a RateLimiter)
+	// between workers to bound their combined rate. A task whose context is done while waiting is not run.
+	Limiter Limiter
+
+	// StatsHook, if non-nil, receives per-task execution events; see StatsHook.
+	StatsHook StatsHook
+
+	// TaskTimeout, if positive, is applied as a timeout to the context passed to each task (in addition to any deadline
+	// of the task's own; see Deadliner). Note that the handler must observe the context for the timeout to take effect.
+	TaskTimeout time.Duration
+
+	// Watchdog, if non-nil, reports tasks that have been running for too long, and can replace the workers running them;
+	// see WatchdogPolicy.
+	Watchdog *WatchdogPolicy
+
+	// OnWorkerStart, if non-nil, is called on each worker goroutine as it starts (including the workers started as a
+	// pool grows), before the worker takes its first task; it returns a per-worker value, e.g. a connection or a
+	// buffer, which is available to each task run by the worker via WorkerValue. If it returns an error, the worker
+	// stops (and is removed from its pool), and the error is reported to OnError (with a nil task).
+	//
+	// Like the other options, OnWorkerStart and OnWorkerStop are only available via the constructors that take Options
+	// (e.g. NewWorkerPoolWithContext, rather than NewWorkerPool); and as the value is reached via the task's context,
+	// only a handler that receives the context (e.g. a ContextHandler) can use it.
+	OnWorkerStart func(workerID uint64) (interface{}, error)
+
+	// OnWorkerStop, if non-nil, is called on each worker goroutine as it stops (however it stops, e.g. as the pool
+	// shrinks), with the value returned by OnWorkerStart, so that the value can be released. It is not called for a
+	// worker whose OnWorkerStart returned an error.
+	OnWorkerStop func(workerID uint64, value interface{})
+}
+
+// validateOptions checks the provided options, which may be nil.
+func validateOptions(opts *Options) error {
+
+	if opts != nil && opts.Retry != nil {
+		if err := validateRetryPolicy(opts.Retry); err != nil {
+			return fmt.Errorf("invalid retry policy: %v", err)
+		}
+	}
+
+	if opts != nil && opts.Watchdog != nil {
+		if err := validateWatchdogPolicy(opts.Watchdog); err != nil {
+			return fmt.Errorf("invalid watchdog policy: %v", err)
+		}
+	}
+
+	if opts != nil && opts.TaskTimeout < 0 {
+		return fmt.Errorf("TaskTimeout cannot be negative (%v)", opts.TaskTimeout)
+	}
+
+	return nil
+}
+
+// copyOptions returns a copy of the provided options, or the zero value if nil.
+func copyOptions(opts *Options) Options {
+	if opts == nil {
+		return Options{}
+	}
+
+	return *opts
+}
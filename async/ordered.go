@@ -0,0 +1,224 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// OrderedHandler processes a single task for an OrderedProcessor, returning its result.
+type OrderedHandler func(ctx context.Context, task interface{}) (interface{}, error)
+
+// OrderedResult is the outcome of a task processed by an OrderedProcessor. Err is the task's final error (after any
+// retries), in which case Value is nil.
+type OrderedResult struct {
+	Task  interface{}
+	Value interface{}
+	Err   error
+}
+
+func (r *OrderedResult) String() string {
+	return fmt.Sprintf("&OrderedResult{task:%v value:%v err:%v}", r.Task, r.Value, r.Err)
+}
+
+// orderedTask is the envelope that carries a task, and its position in the input, through the pool.
+type orderedTask struct {
+	processor *OrderedProcessor
+	seq       uint64
+	task      interface{}
+	value     interface{} // the handler's result
+}
+
+func (t *orderedTask) unwrap() interface{} {
+	return t.task
+}
+
+// handle runs the handler; the result is delivered once the task is done.
+func (t *orderedTask) handle(ctx context.Context) error {
+
+	value, err := t.processor.handle(ctx, t.task)
+	if err == nil {
+		t.value = value
+	}
+
+	return err
+}
+
+func (t *orderedTask) done(err error) {
+	t.processor.completed <- &orderedResult{seq: t.seq, result: &OrderedResult{Task: t.task, Value: t.value, Err: err}}
+}
+
+// OrderedProcessor processes tasks concurrently on a WorkerPool, but emits their results in input order. At most
+// 'window' tasks are between being received from the input and having their result emitted; once the window is full,
+// no further tasks are started until the oldest task completes (and its result is consumed). The window therefore
+// bounds the memory used to hold results that completed out of order.
+//
+// Closing the input channel drains the processor: the remaining tasks are processed, their results emitted, and then
+// the results channel is closed.
+// THREAD-SAFETY: the OrderedProcessor is thread-safe.
+type OrderedProcessor struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	input     chan interface{}
+	handle    OrderedHandler
+	pool      *WorkerPool
+	tasks     chan interface{}    // sequenced tasks, consumed by the pool
+	slots     chan struct{}       // the reorder window (a semaphore)
+	completed chan *orderedResult // buffered to the window size, so that workers never block
+	results   chan *OrderedResult
+	finished  chan struct{} // closed once the results channel has been closed
+	abandon   sync.Once
+}
+
+type orderedResult struct {
+	seq    uint64
+	result *OrderedResult
+}
+
+// NewOrderedProcessor creates an OrderedProcessor with 'workers' workers, consuming tasks from 'input'. 'window' is the
+// size of the reorder window (see OrderedProcessor), and should be at least the number of workers. The options
+// configure the underlying WorkerPool; a task that finally fails is emitted as a result with a non-nil Err.
+// NewOrderedProcessor will return an error if the provided context, input channel, or handler are nil, if 'workers' or
+// 'window' are not positive, or if the options are invalid.
+func NewOrderedProcessor(ctx context.Context, input chan interface{}, workers int, window int, handle OrderedHandler, opts *Options) (*OrderedProcessor, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("context cannot be nil")
+	}
+
+	if input == nil {
+		return nil, fmt.Errorf("input channel cannot be nil")
+	}
+
+	if handle == nil {
+		return nil, fmt.Errorf("handler func cannot be nil")
+	}
+
+	if workers < 1 || window < 1 {
+		return nil, fmt.Errorf("workers and window must be positive (%d, %d)", workers, window)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	o := &OrderedProcessor{
+		ctx:       ctx,
+		cancel:    cancel,
+		input:     input,
+		handle:    handle,
+		tasks:     make(chan interface{}),
+		slots:     make(chan struct{}, window),
+		completed: make(chan *orderedResult, window),
+		results:   make(chan *OrderedResult),
+		finished:  make(chan struct{})}
+
+	pool, err := newOwnedPool(ctx, o.tasks, workers, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	o.pool = pool
+
+	go o.sequence()
+	go o.reorder()
+
+	go func() {
+		pool.Wait()
+		close(o.completed)
+	}()
+
+	go abandonOnDone(ctx, cancel, o.finished, o.Abandon)
+
+	return o, nil
+}
+
+// sequence runs on its own goroutine, numbering the input tasks and passing them to the pool as the window allows.
+func (o *OrderedProcessor) sequence() {
+
+	defer close(o.tasks) // drains the pool
+
+	var seq uint64
+
+	for {
+		select {
+		case o.slots <- struct{}{}:
+		case <-o.ctx.Done():
+			return
+		}
+
+		var task interface{}
+		var ok bool
+
+		select {
+		case task, ok = <-o.input:
+			if !ok {
+				return
+			}
+		case <-o.ctx.Done():
+			return
+		}
+
+		select {
+		case o.tasks <- &orderedTask{processor: o, seq: seq, task: task}:
+			seq++
+		case <-o.ctx.Done():
+			return
+		}
+	}
+}
+
+// reorder runs on its own goroutine, emitting results in sequence and releasing their window slots.
+func (o *OrderedProcessor) reorder() {
+
+	defer close(o.finished)
+	defer close(o.results)
+
+	pending := make(map[uint64]*OrderedResult)
+	var next uint64
+
+	for completed := range o.completed {
+		pending[completed.seq] = completed.result
+
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			select {
+			case o.results <- result:
+			case <-o.ctx.Done():
+				return
+			}
+
+			delete(pending, next)
+			next++
+			<-o.slots
+		}
+	}
+}
+
+// Results returns the channel on which results are emitted, in input order. The channel is closed once the processor
+// has drained, or has been abandoned.
+func (o *OrderedProcessor) Results() <-chan *OrderedResult {
+	return o.results
+}
+
+// Abandon stops the processor: in-flight tasks are abandoned (see WorkerPool.Abandon), no further tasks are taken
+// from the input, no further results are emitted, and the results channel is closed. Abandon is non-blocking.
+func (o *OrderedProcessor) Abandon() {
+	o.abandon.Do(func() {
+		o.cancel()
+		o.pool.Abandon()
+	})
+}
+
+// Wait blocks until the results channel has been closed, and the processor's workers have stopped.
+func (o *OrderedProcessor) Wait() {
+	<-o.finished
+	o.pool.Wait()
+}
+
+func (o *OrderedProcessor) String() string {
+	return fmt.Sprintf("&OrderedProcessor{workers:%d window:%d}", o.pool.Size(), cap(o.slots))
+}
@@ -0,0 +1,151 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OrderedProcessor", func() {
+
+	// delays earlier tasks more than later ones, so that they complete out of order
+	reverseDelay := func(_ context.Context, task interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(10-task.(int)%10) * time.Millisecond)
+		return task.(int) * 10, nil
+	}
+
+	It("validates its arguments", func() {
+		input := make(chan interface{})
+
+		_, err := NewOrderedProcessor(nil, input, 1, 1, reverseDelay, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewOrderedProcessor(context.Background(), nil, 1, 1, reverseDelay, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewOrderedProcessor(context.Background(), input, 1, 1, nil, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewOrderedProcessor(context.Background(), input, 0, 1, reverseDelay, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewOrderedProcessor(context.Background(), input, 1, 0, reverseDelay, nil)
+		Expect(err).NotTo(BeNil())
+
+		_, err = NewOrderedProcessor(context.Background(), input, 1, 1, reverseDelay, &Options{Retry: &RetryPolicy{}})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("emits results in input order, and drains when the input is closed", func(done Done) {
+		input := make(chan interface{})
+		o, err := NewOrderedProcessor(context.Background(), input, 4, 8, reverseDelay, nil)
+		Expect(err).To(BeNil())
+
+		go func() {
+			for i := 0; i < 30; i++ {
+				input <- i
+			}
+			close(input)
+		}()
+
+		i := 0
+		for result := range o.Results() {
+			Expect(result.Task).To(Equal(i))
+			Expect(result.Value).To(Equal(i * 10))
+			Expect(result.Err).To(BeNil())
+			i++
+		}
+		Expect(i).To(Equal(30))
+
+		o.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("emits failed tasks in order, passing the tasks themselves to the options' hooks", func(done Done) {
+		failures := make(chan interface{}, 1)
+
+		input := make(chan interface{}, 3)
+		o, err := NewOrderedProcessor(context.Background(), input, 2, 2, func(_ context.Context, task interface{}) (interface{}, error) {
+			if task == 1 {
+				return nil, fmt.Errorf("failed")
+			}
+			return task, nil
+		}, &Options{
+			PanicPolicy: PanicRestart,
+			OnFailure: func(task interface{}, _ error, _ int) {
+				failures <- task
+			}})
+		Expect(err).To(BeNil())
+
+		input <- 0
+		input <- 1
+		input <- 2
+		close(input)
+
+		var results []*OrderedResult
+		for result := range o.Results() {
+			results = append(results, result)
+		}
+
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].Value).To(Equal(0))
+		Expect(results[1].Task).To(Equal(1))
+		Expect(results[1].Err).To(MatchError("failed"))
+		Expect(results[2].Value).To(Equal(2))
+		Expect(failures).To(Receive(Equal(1)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("bounds the number of tasks in the reorder window", func(done Done) {
+		release := make(chan struct{})
+		var started int32
+
+		input := make(chan interface{}, 20)
+		o, err := NewOrderedProcessor(context.Background(), input, 8, 3, func(_ context.Context, task interface{}) (interface{}, error) {
+			atomic.AddInt32(&started, 1)
+			if task == 0 {
+				<-release // the head of the line is slow
+			}
+			return task, nil
+		}, nil)
+		Expect(err).To(BeNil())
+
+		for i := 0; i < 20; i++ {
+			input <- i
+		}
+		close(input)
+
+		Eventually(func() int32 { return atomic.LoadInt32(&started) }).Should(Equal(int32(3)))
+		Consistently(func() int32 { return atomic.LoadInt32(&started) }, 50*time.Millisecond).Should(Equal(int32(3)))
+
+		close(release)
+
+		count := 0
+		for range o.Results() {
+			count++
+		}
+		Expect(count).To(Equal(20))
+
+		close(done)
+	}, 3) // timeout
+
+	It("closes the results channel when abandoned", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		o, err := NewOrderedProcessor(ctx, make(chan interface{}), 2, 2, reverseDelay, nil)
+		Expect(err).To(BeNil())
+
+		cancel()
+		Eventually(o.Results()).Should(BeClosed())
+		o.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,45 @@
+package async
+
+import (
+	"fmt"
+)
+
+// PanicPolicy selects how a worker handles a panic raised while running a task.
+type PanicPolicy int
+
+const (
+	// PanicPropagate does not recover the panic, which will crash the process. This is the default.
+	PanicPropagate PanicPolicy = iota
+
+	// PanicStop recovers the panic, reports it via Options.OnError, and stops the worker. A worker stopped this way is
+	// removed from its pool.
+	PanicStop
+
+	// PanicRestart recovers the panic, reports it via Options.OnError, and restarts the worker, which resumes
+	// consuming tasks.
+	PanicRestart
+)
+
+func (p PanicPolicy) String() string {
+	switch p {
+	case PanicPropagate:
+		return "PanicPropagate"
+	case PanicStop:
+		return "PanicStop"
+	case PanicRestart:
+		return "PanicRestart"
+	}
+
+	return fmt.Sprintf("PanicPolicy(%d)", int(p))
+}
+
+// PanicError is the error reported when a task panics and the panic is recovered.
+type PanicError struct {
+	Task  interface{} // the task that was being run
+	Value interface{} // the value passed to panic()
+	Stack []byte      // the stack of the panicking goroutine, as captured by runtime/debug.Stack()
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
@@ -0,0 +1,120 @@
+package async_test
+
+import (
+	"context"
+	"sync/atomic"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PanicPolicy", func() {
+
+	var callCount uint32
+	var errs chan error
+	var tasks chan interface{}
+
+	onTask := func(_ context.Context, task interface{}) error {
+		atomic.AddUint32(&callCount, 1)
+		if task == "panic" {
+			panic("boom")
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		callCount = 0
+		errs = make(chan error, 8)
+		tasks = make(chan interface{})
+	})
+
+	newOptions := func(policy PanicPolicy) *Options {
+		return &Options{
+			PanicPolicy: policy,
+			OnError: func(_ interface{}, err error) {
+				errs <- err
+			}}
+	}
+
+	It("is a Stringer", func() {
+		Expect(PanicRestart.String()).To(Equal("PanicRestart"))
+		Expect(PanicPolicy(42).String()).To(ContainSubstring("42"))
+	})
+
+	Describe("PanicStop", func() {
+		It("recovers and reports the panic, then stops the worker", func(done Done) {
+			w, err := NewWorkerWithContext(context.Background(), tasks, onTask, nil, newOptions(PanicStop))
+			Expect(err).To(BeNil())
+
+			tasks <- "panic"
+
+			var panicErr *PanicError
+			Expect(<-errs).To(BeAssignableToTypeOf(panicErr))
+
+			w.Wait() // the worker stopped without the channel being closed or Abandon being called
+
+			close(done)
+		})
+
+		It("removes the stopped worker from its pool", func(done Done) {
+			pool, err := NewWorkerPoolWithContext(context.Background(), tasks, onTask, newOptions(PanicStop))
+			Expect(err).To(BeNil())
+			Expect(pool.Add(2)).To(BeNil())
+
+			tasks <- "panic"
+			<-errs
+
+			Eventually(pool.Size).Should(Equal(1))
+
+			pool.Abandon()
+			pool.Wait()
+
+			close(done)
+		})
+	})
+
+	Describe("PanicRestart", func() {
+		It("recovers and reports the panic, then continues running tasks", func(done Done) {
+			w, err := NewWorkerWithContext(context.Background(), tasks, onTask, nil, newOptions(PanicRestart))
+			Expect(err).To(BeNil())
+
+			tasks <- "panic"
+
+			err = <-errs
+			panicErr, ok := err.(*PanicError)
+			Expect(ok).To(BeTrue())
+			Expect(panicErr.Task).To(Equal("panic"))
+			Expect(panicErr.Value).To(Equal("boom"))
+			Expect(panicErr.Stack).NotTo(BeEmpty())
+			Expect(panicErr.Error()).To(ContainSubstring("boom"))
+
+			tasks <- 1
+			close(tasks)
+			w.Wait()
+
+			Expect(atomic.LoadUint32(&callCount)).To(Equal(uint32(2)))
+
+			close(done)
+		})
+
+		It("completes the Future of a panicking Callable with a PanicError", func(done Done) {
+			pool, err := NewWorkerPoolWithContext(context.Background(), tasks, onTask, newOptions(PanicRestart))
+			Expect(err).To(BeNil())
+			Expect(pool.Add(1)).To(BeNil())
+
+			_, err = pool.Submit(func() (interface{}, error) {
+				panic("boom")
+			}).Get()
+
+			var panicErr *PanicError
+			Expect(err).To(BeAssignableToTypeOf(panicErr))
+
+			pool.Abandon()
+			pool.Wait()
+
+			close(done)
+		})
+	})
+})
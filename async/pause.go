@@ -0,0 +1,99 @@
+package async
+
+import (
+	"sync"
+)
+
+// pauseGate holds back the workers of a paused pool.
+// THREAD-SAFETY: the pauseGate is thread-safe. A nil *pauseGate (i.e. that of a standalone worker) is never paused.
+type pauseGate struct {
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	isPaused bool
+	changed  chan struct{} // closed (and replaced) on each pause or resume
+}
+
+func newPauseGate() *pauseGate {
+	return &pauseGate{
+		mutex:   sync.Mutex{},
+		changed: make(chan struct{})}
+}
+
+// set pauses or resumes; it returns false if there was no change.
+func (g *pauseGate) set(isPaused bool) bool {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.isPaused == isPaused {
+		return false
+	}
+
+	g.isPaused = isPaused
+	close(g.changed)
+	g.changed = make(chan struct{})
+
+	return true
+}
+
+// state returns whether the gate is paused, and a channel that is closed on the next change.
+func (g *pauseGate) state() (bool, <-chan struct{}) {
+
+	if g == nil {
+		return false, nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.isPaused, g.changed
+}
+
+// await blocks while the gate is paused. await returns false if 'abandon' is closed first.
+func (g *pauseGate) await(abandon <-chan struct{}) bool {
+	for {
+		isPaused, changed := g.state()
+		if !isPaused {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-abandon:
+			return false
+		}
+	}
+}
+
+// Pause stops the pool's workers from starting tasks: tasks that are already running finish, but no further tasks
+// (or retries) are started until Resume is called; tasks remain queued in the task channel meanwhile. The workers
+// remain in the pool, and may be added or removed while the pool is paused. Pause has no effect on a pool that has
+// been abandoned or shut down. Autoscaling (see Autoscale) is suspended while the pool is paused.
+//
+// Note that a worker that receives a task at the same moment as the pool is paused holds the task (without starting
+// it) until the pool is resumed, even if the worker is removed meanwhile; if the pool is abandoned instead, the held
+// task is run straight away, with its context cancelled.
+func (p *WorkerPool) Pause() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.isAbandoned || p.isShutdown {
+		return
+	}
+
+	p.gate.set(true)
+}
+
+// Resume resumes a paused pool (see Pause); the workers continue taking tasks from the task channel. Resume has no
+// effect on a pool that is not paused.
+func (p *WorkerPool) Resume() {
+	p.gate.set(false)
+}
+
+// IsPaused returns true if the pool is paused (see Pause).
+func (p *WorkerPool) IsPaused() bool {
+	isPaused, _ := p.gate.state()
+	return isPaused
+}
@@ -0,0 +1,178 @@
+package async_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pausingTask pauses the pool as it is received by a worker (i.e. as its wait time is measured), so that the worker
+// holds it.
+type pausingTask struct {
+	pool *WorkerPool
+}
+
+func (t *pausingTask) Timestamp() time.Time {
+	t.pool.Pause()
+	return time.Now()
+}
+
+var _ = Describe("Pause", func() {
+
+	var tasks chan interface{}
+	var release chan struct{}
+	var handled int32
+	var pool *WorkerPool
+
+	handledCount := func() int32 {
+		return atomic.LoadInt32(&handled)
+	}
+
+	BeforeEach(func() {
+		tasks = make(chan interface{}, 10)
+		release = make(chan struct{})
+		atomic.StoreInt32(&handled, 0)
+
+		var err error
+		pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+			if task == "blocking" {
+				<-release
+			}
+			atomic.AddInt32(&handled, 1)
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("lets in-flight tasks finish, but starts no new ones until resumed", func(done Done) {
+		Expect(pool.Add(2)).To(Succeed())
+
+		tasks <- "blocking"
+		Eventually(func() int { return pool.Stats().Busy }).Should(Equal(1))
+
+		Expect(pool.IsPaused()).To(BeFalse())
+		pool.Pause()
+		pool.Pause() // idempotent
+		Expect(pool.IsPaused()).To(BeTrue())
+
+		for i := 0; i < 5; i++ {
+			tasks <- i
+		}
+		close(release)
+
+		Eventually(handledCount).Should(Equal(int32(1))) // the in-flight task
+		Consistently(handledCount, 50*time.Millisecond).Should(Equal(int32(1)))
+		Expect(len(tasks)).To(BeNumerically(">=", 3)) // at most one task per worker is held
+		Expect(pool.Size()).To(Equal(2))
+
+		pool.Resume()
+		Expect(pool.IsPaused()).To(BeFalse())
+
+		Eventually(handledCount).Should(Equal(int32(6)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("holds back workers added while paused", func(done Done) {
+		pool.Pause()
+		Expect(pool.Add(1)).To(Succeed())
+
+		tasks <- 1
+		Consistently(handledCount, 30*time.Millisecond).Should(BeZero())
+		Expect(len(tasks)).To(Equal(1))
+
+		pool.Resume()
+		Eventually(handledCount).Should(Equal(int32(1)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops removed or abandoned workers while paused", func(done Done) {
+		Expect(pool.Add(2)).To(Succeed())
+		pool.Pause()
+
+		handle, err := pool.Shrink(1)
+		Expect(err).To(BeNil())
+		Expect(handle.Wait(context.Background())).To(Succeed())
+
+		Expect(pool.Halt().Wait(context.Background())).To(Succeed())
+		pool.Resume() // no effect
+
+		close(done)
+	}, 3) // timeout
+
+	It("runs a held task once resumed, if the worker holding it is removed meanwhile", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+
+		tasks <- &pausingTask{pool}
+		Eventually(pool.IsPaused).Should(BeTrue())
+
+		handle, err := pool.Shrink(1)
+		Expect(err).To(BeNil())
+		Consistently(handle.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		Expect(handledCount()).To(BeZero())
+
+		pool.Resume()
+		Expect(handle.Wait(context.Background())).To(Succeed())
+		Expect(handledCount()).To(Equal(int32(1)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("runs a held task with its context cancelled if abandoned", func(done Done) {
+		errs := make(chan error, 1)
+
+		abandoned, err := NewWorkerPoolWithContext(context.Background(), tasks, func(ctx context.Context, _ interface{}) error {
+			atomic.AddInt32(&handled, 1)
+			errs <- ctx.Err()
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+		Expect(abandoned.Add(1)).To(Succeed())
+
+		tasks <- &pausingTask{abandoned}
+		Eventually(abandoned.IsPaused).Should(BeTrue())
+
+		Expect(abandoned.Halt().Wait(context.Background())).To(Succeed())
+		Expect(errs).To(Receive(Equal(context.Canceled)))
+		Expect(handledCount()).To(Equal(int32(1)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("is resumed by Shutdown, in order to drain", func(done Done) {
+		Expect(pool.Add(1)).To(Succeed())
+		pool.Pause()
+
+		for i := 0; i < 3; i++ {
+			tasks <- i
+		}
+
+		report, err := pool.Shutdown(context.Background())
+		Expect(err).To(BeNil())
+		Expect(report.Undone()).To(Equal(0))
+		Expect(handledCount()).To(Equal(int32(3)))
+
+		pool.Pause()
+		Expect(pool.IsPaused()).To(BeFalse()) // no effect once shut down
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,314 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// StageHandler processes a single task for a pipeline stage, passing any number of results to the next stage via
+// 'emit'. emit blocks while the next stage's buffer is full, and returns an error (the context's) if the pipeline has
+// been cancelled. A non-nil error returned by the StageHandler is fatal to the pipeline.
+type StageHandler func(ctx context.Context, task interface{}, emit func(result interface{}) error) error
+
+// PipelineStage configures a pipeline stage; see Pipeline.Stage.
+type PipelineStage struct {
+	Name    string `config:"required"`
+	Workers int    `config:"required"` // number of concurrent workers
+	Buffer  int    // capacity of the stage's output channel
+	Handle  StageHandler
+
+	// Options, if non-nil, configures the stage's WorkerPool (e.g. to add a RetryPolicy or a Limiter). A task that
+	// finally fails (see Options.OnFailure) is fatal to the pipeline.
+	Options *Options
+}
+
+// PipelineError is the fatal error that stopped a pipeline.
+type PipelineError struct {
+	Stage string
+	Task  interface{}
+	Err   error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %q failed: %v", e.Stage, e.Err)
+}
+
+// Pipeline chains WorkerPools into a series of stages, each with its own concurrency, connected by bounded channels.
+// Build the pipeline with Stage, then Start it with an input channel:
+//
+//	p, err := NewPipeline(ctx)
+//	p.Stage(PipelineStage{Name: "fetch", Workers: 8, Buffer: 16, Handle: fetch}).
+//		Stage(PipelineStage{Name: "parse", Workers: 2, Buffer: 16, Handle: parse})
+//	output, err := p.Start(input)
+//	for result := range output { ... }
+//	err = p.Wait()
+//
+// Closing the input channel drains the pipeline: each stage finishes its remaining tasks, then closes its output
+// channel, which drains the following stage, and so on until the output channel is closed. The first fatal error (or
+// the cancellation of the pipeline's context) cancels the pipeline: every stage is abandoned, and the output channel
+// is closed.
+//
+// The output channel must be consumed, unless the final stage emits nothing.
+// THREAD-SAFETY: the Pipeline is thread-safe.
+type Pipeline struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{} // closed once every stage has stopped
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	stages    []*pipelineStage
+	buildErr  error
+	err       error
+	isStarted bool
+}
+
+type pipelineStage struct {
+	config PipelineStage
+	pool   *WorkerPool
+	output chan interface{}
+}
+
+// NewPipeline creates an empty Pipeline; the cancellation of 'ctx' cancels the pipeline.
+// NewPipeline will return an error if the provided context is nil.
+func NewPipeline(ctx context.Context) (*Pipeline, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("context cannot be nil")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Pipeline{
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+		mutex:    sync.Mutex{}}, nil
+}
+
+// Stage appends a stage to the pipeline, and returns the pipeline (for chaining). An invalid stage, or an attempt to
+// add a stage to a started pipeline, is reported by Start.
+func (p *Pipeline) Stage(stage PipelineStage) *Pipeline {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.buildErr != nil {
+		return p
+	}
+
+	if p.isStarted {
+		p.buildErr = fmt.Errorf("tried to add stage %q after the pipeline was started", stage.Name)
+		return p
+	}
+
+	if err := config.ValidateConstraints(&stage); err != nil {
+		p.buildErr = fmt.Errorf("invalid pipeline stage %q: %v", stage.Name, err)
+		return p
+	}
+
+	if stage.Handle == nil {
+		p.buildErr = fmt.Errorf("invalid pipeline stage %q: Handle cannot be nil", stage.Name)
+		return p
+	}
+
+	if stage.Workers < 1 || stage.Buffer < 0 {
+		p.buildErr = fmt.Errorf("invalid pipeline stage %q: Workers must be positive, and Buffer cannot be negative",
+			stage.Name)
+		return p
+	}
+
+	if err := validateOptions(stage.Options); err != nil {
+		p.buildErr = fmt.Errorf("invalid pipeline stage %q: %v", stage.Name, err)
+		return p
+	}
+
+	p.stages = append(p.stages, &pipelineStage{config: stage})
+	return p
+}
+
+// Start starts the pipeline's stages, with the first stage consuming 'input', and returns the final stage's output
+// channel. Start returns an error if the input is nil, the pipeline has no stages or was built with an invalid stage,
+// or if the pipeline has already been started. If a stage fails to start, the stages already started are abandoned,
+// and the pipeline cannot be started again (Start returns the same error, and Wait reports that it has not started).
+// IMPORTANT: do not send on, or close, the returned channel.
+func (p *Pipeline) Start(input chan interface{}) (chan interface{}, error) {
+
+	if input == nil {
+		return nil, fmt.Errorf("input channel cannot be nil")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.buildErr != nil {
+		return nil, p.buildErr
+	}
+
+	if p.isStarted {
+		return nil, fmt.Errorf("the pipeline has already been started")
+	}
+
+	if len(p.stages) == 0 {
+		return nil, fmt.Errorf("the pipeline has no stages")
+	}
+
+	tasks := input
+	for _, stage := range p.stages {
+		stage.output = make(chan interface{}, stage.config.Buffer)
+
+		pool, err := NewWorkerPoolWithContext(p.ctx, tasks, p.stageHandler(stage), p.stageOptions(stage))
+		if err == nil {
+			err = pool.Add(stage.config.Workers)
+		}
+
+		if err != nil {
+			p.abandon() // stop any stages already started
+			p.buildErr = fmt.Errorf("failed to start pipeline stage %q: %v", stage.config.Name, err)
+			return nil, p.buildErr
+		}
+
+		stage.pool = pool
+		tasks = stage.output
+	}
+
+	// only now that every stage has started, as Wait would otherwise block forever on a failed start
+	p.isStarted = true
+
+	// Cascade the drain: once a stage's workers have all stopped, close its output (the next stage's input)
+	var stopped sync.WaitGroup
+	for _, stage := range p.stages {
+		stopped.Add(1)
+		go func(stage *pipelineStage) {
+			defer stopped.Done()
+
+			stage.pool.Wait()
+			close(stage.output)
+		}(stage)
+	}
+
+	go func() {
+		stopped.Wait()
+		close(p.finished)
+	}()
+
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			p.fail(nil, nil, p.ctx.Err())
+		case <-p.finished:
+			p.cancel() // release the context
+		}
+	}()
+
+	return tasks, nil
+}
+
+// stageHandler adapts a StageHandler to the stage's WorkerPool.
+func (p *Pipeline) stageHandler(stage *pipelineStage) ContextHandler {
+	return func(ctx context.Context, task interface{}) error {
+		return stage.config.Handle(ctx, task, func(result interface{}) error {
+			select {
+			case stage.output <- result:
+				return nil
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		})
+	}
+}
+
+// stageOptions returns the options for the stage's WorkerPool, which report final failures to the pipeline.
+func (p *Pipeline) stageOptions(stage *pipelineStage) *Options {
+
+	opts := copyOptions(stage.config.Options)
+	onFailure := opts.OnFailure
+
+	opts.OnFailure = func(task interface{}, err error, attempts int) {
+		if onFailure != nil {
+			onFailure(task, err, attempts)
+		}
+
+		p.fail(stage, task, err)
+	}
+
+	return &opts
+}
+
+// fail records the pipeline's first fatal error, and cancels the pipeline. A nil stage indicates that the pipeline
+// itself was cancelled.
+func (p *Pipeline) fail(stage *pipelineStage, task interface{}, err error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return
+	}
+
+	switch {
+	case stage == nil:
+		p.err = err
+	case p.ctx.Err() != nil:
+		p.err = p.ctx.Err() // the stage failed because the pipeline was cancelled
+	default:
+		p.err = &PipelineError{Stage: stage.config.Name, Task: task, Err: err}
+	}
+
+	p.abandon()
+}
+
+// abandon cancels the pipeline and abandons every started stage; the caller must hold the mutex.
+func (p *Pipeline) abandon() {
+
+	p.cancel()
+
+	for _, stage := range p.stages {
+		if stage.pool != nil {
+			stage.pool.Abandon()
+		}
+	}
+}
+
+// Cancel cancels the pipeline, abandoning every stage; Wait will return context.Canceled. Cancel is non-blocking.
+func (p *Pipeline) Cancel() {
+	p.fail(nil, nil, context.Canceled)
+}
+
+// Wait blocks until every stage of a started pipeline has stopped, and returns the pipeline's fatal error (a
+// *PipelineError, or the context's error if the pipeline was cancelled), or nil if the pipeline drained successfully.
+// Wait returns immediately with an error if the pipeline has not been started.
+func (p *Pipeline) Wait() error {
+
+	p.mutex.Lock()
+	isStarted := p.isStarted
+	p.mutex.Unlock()
+
+	if !isStarted {
+		return fmt.Errorf("the pipeline has not been started")
+	}
+
+	<-p.finished
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.err
+}
+
+func (p *Pipeline) String() string {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.config.Name
+	}
+
+	return fmt.Sprintf("&Pipeline{stages:%v started:%v}", names, p.isStarted)
+}
@@ -0,0 +1,177 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pipeline", func() {
+
+	double := func(_ context.Context, task interface{}, emit func(interface{}) error) error {
+		return emit(task.(int) * 2)
+	}
+
+	It("requires a context", func() {
+		_, err := NewPipeline(nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("reports invalid stages on Start", func() {
+		for _, stage := range []PipelineStage{
+			{Workers: 1, Handle: double},
+			{Name: "stage", Handle: double},
+			{Name: "stage", Workers: 1},
+			{Name: "stage", Workers: 1, Buffer: -1, Handle: double},
+			{Name: "stage", Workers: 1, Handle: double, Options: &Options{Retry: &RetryPolicy{}}}} {
+
+			p, err := NewPipeline(context.Background())
+			Expect(err).To(BeNil())
+
+			_, err = p.Stage(stage).Start(make(chan interface{}))
+			Expect(err).NotTo(BeNil())
+		}
+
+		p, err := NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+		_, err = p.Start(make(chan interface{}))
+		Expect(err).NotTo(BeNil()) // no stages
+
+		Expect(p.Wait()).NotTo(Succeed()) // not started
+	})
+
+	It("runs tasks through each stage, and drains when the input is closed", func(done Done) {
+		p, err := NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+
+		p.Stage(PipelineStage{Name: "double", Workers: 3, Buffer: 2, Handle: double}).
+			Stage(PipelineStage{Name: "fan-out", Workers: 2, Handle: func(_ context.Context, task interface{}, emit func(interface{}) error) error {
+				if err := emit(task); err != nil {
+					return err
+				}
+				return emit(task.(int) + 1)
+			}})
+
+		input := make(chan interface{})
+		output, err := p.Start(input)
+		Expect(err).To(BeNil())
+
+		_, err = p.Start(input)
+		Expect(err).NotTo(BeNil())
+
+		go func() {
+			for i := 0; i < 5; i++ {
+				input <- i
+			}
+			close(input)
+		}()
+
+		var results []int
+		for result := range output {
+			results = append(results, result.(int))
+		}
+		sort.Ints(results)
+
+		Expect(results).To(Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+		Expect(p.Wait()).To(Succeed())
+
+		close(done)
+	}, 3) // timeout
+
+	It("cancels the whole pipeline on the first fatal error", func(done Done) {
+		p, err := NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+
+		p.Stage(PipelineStage{Name: "double", Workers: 2, Handle: double}).
+			Stage(PipelineStage{Name: "fail", Workers: 1, Handle: func(_ context.Context, task interface{}, _ func(interface{}) error) error {
+				if task == 4 {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			}})
+
+		input := make(chan interface{})
+		output, err := p.Start(input)
+		Expect(err).To(BeNil())
+
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case input <- i: // never closed
+				case <-time.After(time.Second):
+					return
+				}
+			}
+		}()
+
+		Eventually(output).Should(BeClosed())
+
+		err = p.Wait()
+		Expect(err).To(BeAssignableToTypeOf(&PipelineError{}))
+		Expect(err.(*PipelineError).Stage).To(Equal("fail"))
+		Expect(err.(*PipelineError).Task).To(Equal(4))
+		Expect(err.(*PipelineError).Err).To(MatchError("failed"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("lets retries run before failing", func(done Done) {
+		p, err := NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+
+		attempts := 0
+		p.Stage(PipelineStage{
+			Name:    "flaky",
+			Workers: 1,
+			Buffer:  1,
+			Options: &Options{Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}},
+			Handle: func(_ context.Context, task interface{}, emit func(interface{}) error) error {
+				if attempts++; attempts < 3 {
+					return fmt.Errorf("flaky")
+				}
+				return emit(task)
+			}})
+
+		input := make(chan interface{}, 1)
+		output, err := p.Start(input)
+		Expect(err).To(BeNil())
+
+		input <- "task"
+		close(input)
+
+		Eventually(output).Should(Receive(Equal("task")))
+		Eventually(output).Should(BeClosed())
+		Expect(p.Wait()).To(Succeed())
+
+		close(done)
+	}, 3) // timeout
+
+	It("is cancelled with its context, or by Cancel", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		p, err := NewPipeline(ctx)
+		Expect(err).To(BeNil())
+		output, err := p.Stage(PipelineStage{Name: "double", Workers: 1, Handle: double}).Start(make(chan interface{}))
+		Expect(err).To(BeNil())
+
+		cancel()
+		Eventually(output).Should(BeClosed())
+		Expect(p.Wait()).To(Equal(context.Canceled))
+
+		p, err = NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+		_, err = p.Stage(PipelineStage{Name: "double", Workers: 1, Handle: double}).Start(make(chan interface{}))
+		Expect(err).To(BeNil())
+
+		p.Cancel()
+		Expect(p.Wait()).To(Equal(context.Canceled))
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,40 @@
+package async
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pipeline", func() {
+
+	It("is not left started when a stage fails to start", func(done Done) {
+		p, err := NewPipeline(context.Background())
+		Expect(err).To(BeNil())
+
+		pass := func(_ context.Context, task interface{}, emit func(interface{}) error) error {
+			return emit(task)
+		}
+
+		p.Stage(PipelineStage{Name: "first", Workers: 1, Handle: pass})
+
+		// bypass Stage's validation, so that the stage's pool fails to start
+		p.stages = append(p.stages, &pipelineStage{config: PipelineStage{
+			Name:    "invalid",
+			Workers: 1,
+			Handle:  pass,
+			Options: &Options{TaskTimeout: -1}}})
+
+		_, err = p.Start(make(chan interface{}))
+		Expect(err).To(MatchError(ContainSubstring(`"invalid"`)))
+
+		Expect(p.Wait()).To(MatchError(ContainSubstring("not been started")))
+		p.stages[0].pool.Wait() // the first stage was abandoned
+
+		_, err = p.Start(make(chan interface{}))
+		Expect(err).To(HaveOccurred())
+
+		close(done)
+	}, 3) // timeout
+})
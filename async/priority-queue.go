@@ -0,0 +1,239 @@
+package async
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PriorityQueue is a priority-aware task source for a WorkerPool (or Worker): tasks are pushed with a numeric
+// priority, and are dispatched to the channel returned by Tasks() in order of priority (higher first), and then in
+// FIFO order. Create the pool with the PriorityQueue's channel in place of a raw tasks channel:
+//
+//	q := NewPriorityQueue(0)
+//	pool, err := NewWorkerPool(q.Tasks(), handleTask)
+//
+// Tasks are dispatched only as workers become ready to receive them, so a backlog of low-priority tasks cannot delay
+// a high-priority task by more than the tasks already in progress.
+//
+// To prevent starvation of low-priority tasks, an optional aging interval may be configured: a task's effective
+// priority is raised by one for each aging interval it spends queued.
+// THREAD-SAFETY: the PriorityQueue is thread-safe.
+type PriorityQueue struct {
+	tasks  chan interface{}
+	notify chan struct{} // signals the dispatcher that the heap has changed (buffered, size 1)
+	aging  time.Duration
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	items       priorityItems
+	seq         uint64
+	isClosed    bool
+	isAbandoned bool
+}
+
+// NewPriorityQueue creates a PriorityQueue and starts its dispatcher. 'aging' is the interval after which a queued
+// task's effective priority is raised by one; zero disables aging. NewPriorityQueue will return an error if 'aging' is
+// negative.
+func NewPriorityQueue(aging time.Duration) (*PriorityQueue, error) {
+
+	if aging < 0 {
+		return nil, fmt.Errorf("aging cannot be negative (%v)", aging)
+	}
+
+	q := &PriorityQueue{
+		tasks:  make(chan interface{}),
+		notify: make(chan struct{}, 1),
+		aging:  aging,
+		mutex:  sync.Mutex{}}
+
+	go q.dispatch()
+
+	return q, nil
+}
+
+// Tasks returns the channel on which tasks are dispatched; pass it to NewWorkerPool (or NewWorker). The channel is
+// closed once the queue is closed and drained, or abandoned.
+// IMPORTANT: do not send on, or close, the returned channel.
+func (q *PriorityQueue) Tasks() chan interface{} {
+	return q.tasks
+}
+
+// Push queues a task with the provided priority (higher priorities are dispatched first). Push does not block.
+// An error is returned on an attempt to push to a closed or abandoned queue.
+func (q *PriorityQueue) Push(task interface{}, priority int) error {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.isClosed || q.isAbandoned {
+		return fmt.Errorf("tried to push a task after the queue has been closed")
+	}
+
+	q.seq++
+	heap.Push(&q.items, &priorityItem{
+		task:  task,
+		score: q.score(priority, time.Now()),
+		seq:   q.seq})
+
+	q.signal()
+	return nil
+}
+
+// Len returns the number of queued tasks (not including a task the dispatcher may be holding for a ready worker).
+func (q *PriorityQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items)
+}
+
+// Close stops the queue from accepting further tasks; the remaining tasks will be dispatched, then the Tasks()
+// channel will be closed (which acts as a drain for the workers consuming it). Close is non-blocking.
+func (q *PriorityQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.isClosed = true
+	q.signal()
+}
+
+// Abandon stops the queue from accepting further tasks, discards any remaining tasks, and closes the Tasks()
+// channel. Abandon is non-blocking.
+func (q *PriorityQueue) Abandon() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.isAbandoned = true
+	q.items = nil
+	q.signal()
+}
+
+func (q *PriorityQueue) String() string {
+	return fmt.Sprintf("&PriorityQueue{len:%d}", q.Len())
+}
+
+// score computes the (time-invariant) sort key of a task. With aging, a task's effective priority at time 'now' is
+// 'priority + (now - enqueued) / aging'; comparing two such values at any common 'now' is equivalent to comparing
+// 'priority*aging - enqueued', which is therefore used as the key. Without aging the key is just the priority.
+//
+// The arithmetic saturates rather than overflowing, so that an extreme priority sorts ahead of (or behind) every
+// other task, rather than wrapping around; tasks whose keys saturate are dispatched in FIFO order.
+func (q *PriorityQueue) score(priority int, enqueued time.Time) int64 {
+	if q.aging == 0 {
+		return int64(priority)
+	}
+
+	return saturatingSub(saturatingMul(int64(priority), int64(q.aging)), enqueued.UnixNano())
+}
+
+// saturatingMul returns a*b, clamped to the range of int64; 'b' must be positive.
+func saturatingMul(a int64, b int64) int64 {
+	switch {
+	case a > 0 && a > math.MaxInt64/b:
+		return math.MaxInt64
+	case a < 0 && a < math.MinInt64/b:
+		return math.MinInt64
+	}
+
+	return a * b
+}
+
+// saturatingSub returns a-b, clamped to the range of int64.
+func saturatingSub(a int64, b int64) int64 {
+	switch {
+	case b > 0 && a < math.MinInt64+b:
+		return math.MinInt64
+	case b < 0 && a > math.MaxInt64+b:
+		return math.MaxInt64
+	}
+
+	return a - b
+}
+
+// signal wakes the dispatcher; the caller must hold the mutex.
+func (q *PriorityQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// dispatch runs on its own goroutine, sending the highest-priority task to the Tasks() channel whenever a worker is
+// ready for it. While waiting for a worker, a newly-pushed task may displace the held task.
+func (q *PriorityQueue) dispatch() {
+
+	defer close(q.tasks)
+
+	for {
+		q.mutex.Lock()
+
+		if q.isAbandoned || (q.isClosed && len(q.items) == 0) {
+			q.mutex.Unlock()
+			return
+		}
+
+		if len(q.items) == 0 {
+			q.mutex.Unlock()
+			<-q.notify
+			continue
+		}
+
+		item := heap.Pop(&q.items).(*priorityItem)
+		q.mutex.Unlock()
+
+		select {
+		case q.tasks <- item.task:
+
+		case <-q.notify:
+			// the heap changed (or the queue was closed/abandoned); return the held task and re-evaluate
+			q.mutex.Lock()
+			if !q.isAbandoned {
+				heap.Push(&q.items, item)
+			}
+			q.mutex.Unlock()
+		}
+	}
+}
+
+// priorityItem is a queued task.
+type priorityItem struct {
+	task  interface{}
+	score int64
+	seq   uint64 // FIFO tie-breaker
+}
+
+// priorityItems implements heap.Interface as a max-heap on score, then a min-heap on seq.
+type priorityItems []*priorityItem
+
+func (items priorityItems) Len() int {
+	return len(items)
+}
+
+func (items priorityItems) Less(i, j int) bool {
+	if items[i].score != items[j].score {
+		return items[i].score > items[j].score
+	}
+
+	return items[i].seq < items[j].seq
+}
+
+func (items priorityItems) Swap(i, j int) {
+	items[i], items[j] = items[j], items[i]
+}
+
+func (items *priorityItems) Push(x interface{}) {
+	*items = append(*items, x.(*priorityItem))
+}
+
+func (items *priorityItems) Pop() interface{} {
+	old := *items
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*items = old[:n-1]
+	return item
+}
@@ -0,0 +1,149 @@
+package async_test
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PriorityQueue", func() {
+
+	var q *PriorityQueue
+
+	BeforeEach(func() {
+		var err error
+		q, err = NewPriorityQueue(0)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		q.Abandon()
+	})
+
+	It("is a Stringer", func() {
+		var _ fmt.Stringer = (*PriorityQueue)(nil)
+
+		Expect(fmt.Sprintf("%v", q)).To(ContainSubstring("PriorityQueue"))
+	})
+
+	Describe("NewPriorityQueue", func() {
+		It("returns an error on a negative aging interval", func() {
+			_, err := NewPriorityQueue(-time.Second)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("dispatches tasks by priority, then in FIFO order", func(done Done) {
+		Expect(q.Push("low-1", 1)).To(Succeed())
+		Expect(q.Push("high", 10)).To(Succeed())
+		Expect(q.Push("low-2", 1)).To(Succeed())
+		Expect(q.Push("mid", 5)).To(Succeed())
+
+		Eventually(q.Len).Should(Equal(3)) // the dispatcher holds one task
+
+		var received []interface{}
+		for i := 0; i < 4; i++ {
+			received = append(received, <-q.Tasks())
+		}
+
+		Expect(received).To(Equal([]interface{}{"high", "mid", "low-1", "low-2"}))
+
+		close(done)
+	}, 3) // timeout
+
+	It("drains the remaining tasks, then closes the channel on Close", func(done Done) {
+		Expect(q.Push(1, 0)).To(Succeed())
+		Expect(q.Push(2, 0)).To(Succeed())
+		q.Close()
+
+		Expect(q.Push(3, 0)).To(HaveOccurred())
+
+		var received []interface{}
+		for task := range q.Tasks() {
+			received = append(received, task)
+		}
+
+		Expect(received).To(Equal([]interface{}{1, 2}))
+
+		close(done)
+	}, 3) // timeout
+
+	It("discards the remaining tasks and closes the channel on Abandon", func(done Done) {
+		Expect(q.Push(1, 0)).To(Succeed())
+		Expect(q.Push(2, 0)).To(Succeed())
+		q.Abandon()
+
+		Expect(q.Push(3, 0)).To(HaveOccurred())
+
+		Eventually(q.Tasks()).Should(BeClosed())
+
+		close(done)
+	}, 3) // timeout
+
+	It("raises the effective priority of waiting tasks when aging is enabled", func(done Done) {
+		aging, err := NewPriorityQueue(10 * time.Millisecond)
+		Expect(err).To(BeNil())
+		defer aging.Abandon()
+
+		Expect(aging.Push("old", 0)).To(Succeed())
+		time.Sleep(50 * time.Millisecond) // "old" is now effectively priority 5
+		Expect(aging.Push("new", 3)).To(Succeed())
+		Expect(aging.Push("urgent", 100)).To(Succeed())
+
+		Eventually(aging.Len).Should(Equal(2))
+
+		Expect(<-aging.Tasks()).To(Equal("urgent"))
+		Expect(<-aging.Tasks()).To(Equal("old"))
+		Expect(<-aging.Tasks()).To(Equal("new"))
+
+		close(done)
+	}, 3) // timeout
+
+	It("orders extreme priorities correctly when aging is enabled", func(done Done) {
+		aging, err := NewPriorityQueue(time.Hour)
+		Expect(err).To(BeNil())
+		defer aging.Abandon()
+
+		overflow := int(math.MaxInt64/int64(time.Hour)) + 1 // priority*aging overflows int64
+
+		Expect(aging.Push("normal", 0)).To(Succeed())
+		Expect(aging.Push("lowest", -overflow)).To(Succeed())
+		Expect(aging.Push("highest", overflow)).To(Succeed())
+		Expect(aging.Push("high", math.MaxInt32)).To(Succeed()) // saturates too, so is dispatched in FIFO order
+		Expect(aging.Push("low", -1)).To(Succeed())
+
+		Eventually(aging.Len).Should(Equal(4))
+
+		for _, expected := range []string{"highest", "high", "normal", "low", "lowest"} {
+			Expect(<-aging.Tasks()).To(Equal(expected))
+		}
+
+		close(done)
+	}, 3) // timeout
+
+	It("feeds a WorkerPool", func(done Done) {
+		results := make(chan interface{}, 3)
+
+		pool, err := NewWorkerPool(q.Tasks(), func(task interface{}) {
+			results <- task
+		})
+		Expect(err).To(BeNil())
+
+		Expect(q.Push("a", 0)).To(Succeed())
+		Expect(q.Push("b", 1)).To(Succeed())
+		q.Close()
+
+		Expect(pool.Add(1)).To(Succeed())
+		pool.Wait()
+
+		Expect(<-results).To(Equal("b"))
+		Expect(<-results).To(Equal("a"))
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,204 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter gates the running of tasks; a Limiter shared by all the workers of a pool bounds the pool's overall rate
+// of task dispatch (see Options.Limiter).
+type Limiter interface {
+	// Wait blocks until the task may be run, or until the context is done, in which case the context's error is
+	// returned.
+	Wait(ctx context.Context, task interface{}) error
+}
+
+// RateLimiter is a token-bucket Limiter: tokens are added at a fixed rate up to a maximum (the burst size), and each
+// task consumes one token. Waiters are served in FIFO order.
+// THREAD-SAFETY: the RateLimiter is thread-safe.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	tokens float64 // may be negative, representing reservations made by waiters
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows 'rate' tasks per second on average, with bursts of up to 'burst'
+// tasks. The bucket is initially full.
+// NewRateLimiter will return an error if 'rate' or 'burst' are not positive.
+func NewRateLimiter(rate float64, burst int) (*RateLimiter, error) {
+
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil, fmt.Errorf("rate must be a positive number (%v)", rate)
+	}
+
+	if burst <= 0 {
+		return nil, fmt.Errorf("burst must be positive (%d)", burst)
+	}
+
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		mutex:  sync.Mutex{},
+		tokens: float64(burst),
+		last:   time.Now()}, nil
+}
+
+// advance adds the tokens accrued since the last update; the caller must hold the mutex.
+func (l *RateLimiter) advance(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.tokens+elapsed.Seconds()*l.rate, l.burst)
+		l.last = now
+	}
+}
+
+// Allow consumes a token and returns true if one is available now, otherwise Allow returns false.
+func (l *RateLimiter) Allow() bool {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.advance(time.Now())
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+
+	return false
+}
+
+// Wait blocks until a token is available (consuming it), or until the context is done. The task is ignored.
+func (l *RateLimiter) Wait(ctx context.Context, _ interface{}) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	l.advance(now)
+	l.tokens-- // reserve a token; if none are available, this is a reservation against future tokens
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		// cancel the reservation
+		l.mutex.Lock()
+		l.advance(time.Now())
+		l.tokens = math.Min(l.tokens+1, l.burst)
+		l.mutex.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+func (l *RateLimiter) String() string {
+	return fmt.Sprintf("&RateLimiter{rate:%v burst:%v}", l.rate, l.burst)
+}
+
+// KeyedRateLimiter is a Limiter that maintains a separate token bucket (see RateLimiter) for each task key, as
+// determined by a key function. Buckets that have refilled completely are evicted over time.
+// THREAD-SAFETY: the KeyedRateLimiter is thread-safe.
+type KeyedRateLimiter struct {
+	rate  float64
+	burst int
+	keyOf func(task interface{}) string
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	limiters  map[string]*RateLimiter
+	lastSweep time.Time
+}
+
+// NewKeyedRateLimiter returns a KeyedRateLimiter that allows, per key, 'rate' tasks per second on average with bursts
+// of up to 'burst' tasks; see NewRateLimiter. 'keyOf' returns the key of a task.
+// NewKeyedRateLimiter will return an error if 'rate' or 'burst' are not positive, or 'keyOf' is nil.
+func NewKeyedRateLimiter(rate float64, burst int, keyOf func(task interface{}) string) (*KeyedRateLimiter, error) {
+
+	if _, err := NewRateLimiter(rate, burst); err != nil {
+		return nil, err
+	}
+
+	if keyOf == nil {
+		return nil, fmt.Errorf("keyOf func cannot be nil")
+	}
+
+	return &KeyedRateLimiter{
+		rate:      rate,
+		burst:     burst,
+		keyOf:     keyOf,
+		mutex:     sync.Mutex{},
+		limiters:  make(map[string]*RateLimiter),
+		lastSweep: time.Now()}, nil
+}
+
+// limiter returns the RateLimiter for the provided key, creating it if necessary, and occasionally evicts idle
+// limiters.
+func (k *KeyedRateLimiter) limiter(key string) *RateLimiter {
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	now := time.Now()
+
+	// A limiter that has been idle for long enough to refill completely is indistinguishable from a new one
+	refill := time.Duration(float64(k.burst) / k.rate * float64(time.Second))
+	if now.Sub(k.lastSweep) > refill {
+		for candidateKey, candidate := range k.limiters {
+			candidate.mutex.Lock()
+			candidate.advance(now)
+			isFull := candidate.tokens >= candidate.burst
+			candidate.mutex.Unlock()
+
+			if isFull {
+				delete(k.limiters, candidateKey)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	l, ok := k.limiters[key]
+	if !ok {
+		l, _ = NewRateLimiter(k.rate, k.burst) // the parameters were validated on construction
+		k.limiters[key] = l
+	}
+
+	return l
+}
+
+// Wait blocks until a token is available in the bucket for the task's key (consuming it), or until the context is
+// done.
+func (k *KeyedRateLimiter) Wait(ctx context.Context, task interface{}) error {
+	return k.limiter(k.keyOf(task)).Wait(ctx, task)
+}
+
+// Len returns the number of keys currently being tracked.
+func (k *KeyedRateLimiter) Len() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return len(k.limiters)
+}
@@ -0,0 +1,148 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+
+	It("is a Stringer", func() {
+		var _ fmt.Stringer = (*RateLimiter)(nil)
+
+		l, err := NewRateLimiter(1, 1)
+		Expect(err).To(BeNil())
+		Expect(fmt.Sprintf("%v", l)).To(ContainSubstring("RateLimiter"))
+	})
+
+	Describe("NewRateLimiter", func() {
+		It("requires a positive rate and burst", func() {
+			_, err := NewRateLimiter(0, 1)
+			Expect(err).To(HaveOccurred())
+
+			_, err = NewRateLimiter(1, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Allow", func() {
+		It("allows a burst, then refills at the configured rate", func() {
+			l, err := NewRateLimiter(100, 2)
+			Expect(err).To(BeNil())
+
+			Expect(l.Allow()).To(BeTrue())
+			Expect(l.Allow()).To(BeTrue())
+			Expect(l.Allow()).To(BeFalse())
+
+			Eventually(l.Allow).Should(BeTrue())
+		})
+	})
+
+	Describe("Wait", func() {
+		It("blocks until a token is available", func(done Done) {
+			l, err := NewRateLimiter(50, 1) // a token every 20ms
+			Expect(err).To(BeNil())
+
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				Expect(l.Wait(context.Background(), nil)).To(Succeed())
+			}
+
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+			close(done)
+		}, 3) // timeout
+
+		It("returns the context's error if the context is done first, and releases the reservation", func(done Done) {
+			l, err := NewRateLimiter(1, 1)
+			Expect(err).To(BeNil())
+			Expect(l.Allow()).To(BeTrue())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			Expect(l.Wait(ctx, nil)).To(Equal(context.DeadlineExceeded))
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	It("throttles a WorkerPool across all of its workers", func(done Done) {
+		l, err := NewRateLimiter(100, 1) // a token every 10ms
+		Expect(err).To(BeNil())
+
+		var callCount uint32
+		tasks := make(chan interface{}, 5)
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(context.Context, interface{}) error {
+			atomic.AddUint32(&callCount, 1)
+			return nil
+		}, &Options{Limiter: l})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(4)).To(Succeed())
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			tasks <- i
+		}
+		close(tasks)
+		pool.Wait()
+
+		Expect(atomic.LoadUint32(&callCount)).To(Equal(uint32(5)))
+		Expect(time.Since(start)).To(BeNumerically(">=", 35*time.Millisecond))
+
+		close(done)
+	}, 3) // timeout
+})
+
+var _ = Describe("KeyedRateLimiter", func() {
+
+	keyOf := func(task interface{}) string {
+		return task.(string)
+	}
+
+	It("requires a positive rate and burst, and a key func", func() {
+		_, err := NewKeyedRateLimiter(0, 1, keyOf)
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewKeyedRateLimiter(1, 1, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("maintains a bucket per key", func(done Done) {
+		k, err := NewKeyedRateLimiter(1, 1, keyOf)
+		Expect(err).To(BeNil())
+
+		// each key has its own full bucket, so neither waits
+		Expect(k.Wait(context.Background(), "a")).To(Succeed())
+		Expect(k.Wait(context.Background(), "b")).To(Succeed())
+		Expect(k.Len()).To(Equal(2))
+
+		// ...but a second task for the same key must wait
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Expect(k.Wait(ctx, "a")).To(Equal(context.DeadlineExceeded))
+
+		close(done)
+	}, 3) // timeout
+
+	It("evicts idle buckets", func(done Done) {
+		k, err := NewKeyedRateLimiter(1000, 1, keyOf) // refills in 1ms
+		Expect(err).To(BeNil())
+
+		Expect(k.Wait(context.Background(), "a")).To(Succeed())
+		time.Sleep(5 * time.Millisecond)
+		Expect(k.Wait(context.Background(), "b")).To(Succeed())
+
+		Expect(k.Len()).To(Equal(1))
+
+		close(done)
+	}, 3) // timeout
+})
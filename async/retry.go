@@ -0,0 +1,195 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// JitterMode selects how random jitter is applied to retry backoffs.
+type JitterMode int
+
+const (
+	// NoJitter uses the computed backoff as-is. This is the default.
+	NoJitter JitterMode = iota
+
+	// FullJitter uses a random backoff in [0, backoff].
+	FullJitter
+
+	// EqualJitter uses a random backoff in [backoff/2, backoff].
+	EqualJitter
+)
+
+func (j JitterMode) String() string {
+	switch j {
+	case NoJitter:
+		return "NoJitter"
+	case FullJitter:
+		return "FullJitter"
+	case EqualJitter:
+		return "EqualJitter"
+	}
+
+	return fmt.Sprintf("JitterMode(%d)", int(j))
+}
+
+// RetryPolicy configures the retrying of failed tasks (see Options.Retry). A task whose handler returns a retryable
+// error is re-enqueued after a backoff, until it succeeds or MaxAttempts is reached.
+//
+// The backoff before attempt n+1 is InitialBackoff * Multiplier^(n-1), capped at MaxBackoff, with jitter applied.
+type RetryPolicy struct {
+	MaxAttempts    int `config:"required"` // total number of attempts, including the first
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration // zero for no cap
+	Multiplier     float64       // default 2
+	Jitter         JitterMode
+
+	// Retryable classifies errors; nil retries all errors other than context.Canceled and context.DeadlineExceeded.
+	Retryable func(err error) bool
+}
+
+func validateRetryPolicy(policy *RetryPolicy) error {
+
+	if err := config.ValidateConstraints(policy); err != nil {
+		return err
+	}
+
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("MaxAttempts must be positive (%d)", policy.MaxAttempts)
+	}
+
+	if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+		return fmt.Errorf("backoffs cannot be negative")
+	}
+
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return fmt.Errorf("Multiplier cannot be less than 1 (%v)", policy.Multiplier)
+	}
+
+	return nil
+}
+
+// Backoff returns the (jittered) delay before the attempt following attempt number 'attempt' (starting from 1).
+func (policy *RetryPolicy) Backoff(attempt int) time.Duration {
+
+	multiplier := policy.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 {
+		backoff = math.Min(backoff, float64(policy.MaxBackoff))
+	}
+	backoff = math.Min(backoff, math.MaxInt64) // guard the conversion below
+
+	switch policy.Jitter {
+	case FullJitter:
+		backoff = rand.Float64() * backoff
+	case EqualJitter:
+		backoff = backoff/2 + rand.Float64()*backoff/2
+	}
+
+	return time.Duration(backoff)
+}
+
+// isRetryable determines if an error should be retried.
+func (policy *RetryPolicy) isRetryable(err error) bool {
+
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// retryTask is the envelope that carries a task back to the workers for another attempt.
+type retryTask struct {
+	task         interface{}
+	attempts     int // attempts made so far
+	firstAttempt time.Time
+	due          time.Time
+}
+
+// Timestamp returns the time at which the retry became due (see Timestamped).
+func (t *retryTask) Timestamp() time.Time {
+	return t.due
+}
+
+// retrier re-enqueues failed tasks, after a backoff, onto a channel that is consumed by the workers alongside the
+// task channel. It is shared by all workers of a pool.
+type retrier struct {
+	policy  RetryPolicy
+	retries chan interface{}
+	ctx     context.Context // pending retries are dropped once done
+
+	// mutex covers everything below:
+	mutex sync.Mutex
+
+	pending int           // retries that are scheduled or being run
+	idle    chan struct{} // closed (and replaced) when pending drops to zero
+}
+
+func newRetrier(ctx context.Context, policy RetryPolicy) *retrier {
+	return &retrier{
+		policy:  policy,
+		retries: make(chan interface{}),
+		ctx:     ctx,
+		idle:    make(chan struct{})}
+}
+
+// schedule re-enqueues a task after the policy's backoff.
+func (r *retrier) schedule(task *retryTask) {
+
+	r.mutex.Lock()
+	r.pending++
+	r.mutex.Unlock()
+
+	delay := r.policy.Backoff(task.attempts)
+	task.due = time.Now().Add(delay)
+
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			select {
+			case r.retries <- task:
+				return
+			case <-r.ctx.Done():
+			}
+
+		case <-r.ctx.Done():
+		}
+
+		r.finished() // dropped
+	}()
+}
+
+// finished marks a scheduled retry as having been run (or dropped).
+func (r *retrier) finished() {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pending--
+	if r.pending == 0 {
+		close(r.idle)
+		r.idle = make(chan struct{})
+	}
+}
+
+// status returns the number of pending retries, and a channel that will be closed when that number drops to zero.
+func (r *retrier) status() (int, <-chan struct{}) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.pending, r.idle
+}
@@ -0,0 +1,164 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+
+	Describe("Backoff", func() {
+		It("grows exponentially up to the cap", func() {
+			policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+			Expect(policy.Backoff(1)).To(Equal(10 * time.Millisecond))
+			Expect(policy.Backoff(2)).To(Equal(20 * time.Millisecond))
+			Expect(policy.Backoff(3)).To(Equal(40 * time.Millisecond))
+			Expect(policy.Backoff(4)).To(Equal(50 * time.Millisecond))
+		})
+
+		It("applies the multiplier", func() {
+			policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Multiplier: 3}
+			Expect(policy.Backoff(3)).To(Equal(9 * time.Millisecond))
+		})
+
+		It("applies full jitter within [0, backoff]", func() {
+			policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, Jitter: FullJitter}
+			for i := 0; i < 100; i++ {
+				Expect(policy.Backoff(1)).To(BeNumerically("<=", time.Second))
+			}
+		})
+
+		It("applies equal jitter within [backoff/2, backoff]", func() {
+			policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, Jitter: EqualJitter}
+			for i := 0; i < 100; i++ {
+				Expect(policy.Backoff(1)).To(BeNumerically(">=", 500*time.Millisecond))
+				Expect(policy.Backoff(1)).To(BeNumerically("<=", time.Second))
+			}
+		})
+	})
+
+	It("is validated by the constructors", func() {
+		tasks := make(chan interface{})
+		onTask := func(context.Context, interface{}) error { return nil }
+
+		_, err := NewWorkerPoolWithContext(context.Background(), tasks, onTask, &Options{Retry: &RetryPolicy{}})
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewWorkerWithContext(context.Background(), tasks, onTask, nil, &Options{Retry: &RetryPolicy{MaxAttempts: 2, Multiplier: 0.5}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("with a WorkerPool", func() {
+
+		type failure struct {
+			task     interface{}
+			err      error
+			attempts int
+		}
+
+		var mutex sync.Mutex
+		var attempts map[interface{}]int
+		var failures chan failure
+		var tasks chan interface{}
+
+		// Tasks are ints: the number of attempts that fail before the task succeeds
+		onTask := func(_ context.Context, task interface{}) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			attempts[task]++
+			if attempts[task] <= task.(int) {
+				return fmt.Errorf("attempt %d failed", attempts[task])
+			}
+			return nil
+		}
+
+		attemptsOf := func(task interface{}) int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return attempts[task]
+		}
+
+		BeforeEach(func() {
+			attempts = make(map[interface{}]int)
+			failures = make(chan failure, 8)
+			tasks = make(chan interface{}, 8)
+		})
+
+		newPool := func(policy *RetryPolicy) *WorkerPool {
+			pool, err := NewWorkerPoolWithContext(context.Background(), tasks, onTask, &Options{
+				Retry: policy,
+				OnFailure: func(task interface{}, err error, attempts int) {
+					failures <- failure{task, err, attempts}
+				}})
+			Expect(err).To(BeNil())
+			Expect(pool.Add(2)).To(Succeed())
+			return pool
+		}
+
+		It("retries failed tasks until they succeed, and drains pending retries on close", func(done Done) {
+			pool := newPool(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+			tasks <- 2
+			tasks <- 0
+			close(tasks)
+			pool.Wait()
+
+			Expect(attemptsOf(2)).To(Equal(3))
+			Expect(attemptsOf(0)).To(Equal(1))
+			Expect(failures).To(BeEmpty())
+
+			close(done)
+		}, 3) // timeout
+
+		It("reports final failures with the attempt count", func(done Done) {
+			pool := newPool(&RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+			tasks <- 5
+			close(tasks)
+			pool.Wait()
+
+			f := <-failures
+			Expect(f.task).To(Equal(5))
+			Expect(f.err).To(MatchError("attempt 2 failed"))
+			Expect(f.attempts).To(Equal(2))
+
+			close(done)
+		}, 3) // timeout
+
+		It("does not retry errors classified as non-retryable", func(done Done) {
+			pool := newPool(&RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: time.Millisecond,
+				Retryable:      func(error) bool { return false }})
+
+			tasks <- 1
+			close(tasks)
+			pool.Wait()
+
+			Expect((<-failures).attempts).To(Equal(1))
+
+			close(done)
+		}, 3) // timeout
+
+		It("drops pending retries when the pool is abandoned", func(done Done) {
+			pool := newPool(&RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour})
+
+			tasks <- 1
+			Eventually(func() int { return attemptsOf(1) }).Should(Equal(1))
+
+			pool.Abandon()
+			pool.Wait()
+
+			close(done)
+		}, 3) // timeout
+	})
+})
@@ -0,0 +1,257 @@
+package async
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ScheduledTask is the handle of a task scheduled via a Scheduler, which can be used to cancel it.
+type ScheduledTask struct {
+	task      interface{}
+	due       time.Time
+	seq       uint64 // FIFO tie-breaker
+	scheduler *Scheduler
+	index     int // the position in the scheduler's heap, or -1 once dispatched or cancelled
+}
+
+// Task returns the scheduled task.
+func (t *ScheduledTask) Task() interface{} {
+	return t.task
+}
+
+// Due returns the time at which the task is due.
+func (t *ScheduledTask) Due() time.Time {
+	return t.due
+}
+
+// Cancel cancels the task, returning true if it was still pending (i.e. it had not yet been dispatched, cancelled,
+// or discarded by Stop).
+func (t *ScheduledTask) Cancel() bool {
+	return t.scheduler.cancel(t)
+}
+
+func (t *ScheduledTask) String() string {
+	return fmt.Sprintf("&ScheduledTask{due:%v task:%v}", t.due, t.task)
+}
+
+// Scheduler holds tasks until they are due, and then sends them on a task channel, e.g. that of a WorkerPool:
+//
+//	s, err := NewScheduler(tasks)
+//	pool, err := NewWorkerPool(tasks, handleTask)
+//	handle, err := s.After(30*time.Second, task)
+//
+// Pending tasks are kept in a heap ordered by due time, serviced by a single goroutine and timer, so scheduling and
+// cancellation are O(log n) and hundreds of thousands of pending tasks have no per-task goroutine or timer cost.
+// Tasks due at the same time are dispatched in the order they were scheduled.
+//
+// A due task is sent on the task channel as soon as the channel accepts it; while the send is blocked (e.g. because
+// every worker is busy), later tasks wait behind it.
+// THREAD-SAFETY: the Scheduler is thread-safe.
+type Scheduler struct {
+	tasks  chan interface{}
+	notify chan struct{} // signals the dispatcher that the earliest due time may have changed (buffered, size 1)
+	done   chan struct{} // closed on Stop
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	items     scheduledItems
+	seq       uint64
+	isStopped bool
+}
+
+// NewScheduler creates a Scheduler that sends due tasks on 'tasks', and starts its dispatcher.
+// NewScheduler will return an error if 'tasks' is nil.
+func NewScheduler(tasks chan interface{}) (*Scheduler, error) {
+
+	if tasks == nil {
+		return nil, fmt.Errorf("tasks channel cannot be nil")
+	}
+
+	s := &Scheduler{
+		tasks:  tasks,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		mutex:  sync.Mutex{}}
+
+	go s.dispatch()
+
+	return s, nil
+}
+
+// After schedules a task to be sent after the provided delay (a non-positive delay schedules the task immediately).
+// An error is returned if the scheduler has been stopped.
+func (s *Scheduler) After(delay time.Duration, task interface{}) (*ScheduledTask, error) {
+	return s.At(time.Now().Add(delay), task)
+}
+
+// At schedules a task to be sent at the provided time (a time in the past schedules the task immediately).
+// An error is returned if the scheduler has been stopped.
+func (s *Scheduler) At(due time.Time, task interface{}) (*ScheduledTask, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isStopped {
+		return nil, fmt.Errorf("tried to schedule a task after the scheduler has been stopped")
+	}
+
+	s.seq++
+	t := &ScheduledTask{
+		task:      task,
+		due:       due,
+		seq:       s.seq,
+		scheduler: s}
+
+	heap.Push(&s.items, t)
+
+	if t.index == 0 {
+		s.signal() // the new earliest task
+	}
+
+	return t, nil
+}
+
+func (s *Scheduler) cancel(t *ScheduledTask) bool {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+
+	wasEarliest := t.index == 0
+	heap.Remove(&s.items, t.index)
+
+	if wasEarliest {
+		s.signal()
+	}
+
+	return true
+}
+
+// Len returns the number of pending tasks.
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.items)
+}
+
+// Stop stops the scheduler, discarding any pending tasks; the task channel is not closed. Stop is non-blocking.
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isStopped {
+		return
+	}
+
+	s.isStopped = true
+	for _, t := range s.items {
+		t.index = -1
+	}
+	s.items = nil
+	close(s.done)
+}
+
+func (s *Scheduler) String() string {
+	return fmt.Sprintf("&Scheduler{len:%d}", s.Len())
+}
+
+// signal wakes the dispatcher; the caller must hold the mutex.
+func (s *Scheduler) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// dispatch runs on its own goroutine, sending each task on the task channel once it is due.
+func (s *Scheduler) dispatch() {
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		// stop (and drain) the timer so that it can be reset
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		s.mutex.Lock()
+
+		var due *ScheduledTask
+		var wait <-chan time.Time // nil (never ready) if nothing is pending
+
+		if len(s.items) > 0 {
+			if delay := time.Until(s.items[0].due); delay <= 0 {
+				due = heap.Pop(&s.items).(*ScheduledTask)
+			} else {
+				timer.Reset(delay)
+				wait = timer.C
+			}
+		}
+
+		s.mutex.Unlock()
+
+		if due != nil {
+			select {
+			case s.tasks <- due.task:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-wait:
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// scheduledItems implements heap.Interface as a min-heap on due time, then on seq.
+type scheduledItems []*ScheduledTask
+
+func (items scheduledItems) Len() int {
+	return len(items)
+}
+
+func (items scheduledItems) Less(i, j int) bool {
+	if !items[i].due.Equal(items[j].due) {
+		return items[i].due.Before(items[j].due)
+	}
+
+	return items[i].seq < items[j].seq
+}
+
+func (items scheduledItems) Swap(i, j int) {
+	items[i], items[j] = items[j], items[i]
+	items[i].index = i
+	items[j].index = j
+}
+
+func (items *scheduledItems) Push(x interface{}) {
+	t := x.(*ScheduledTask)
+	t.index = len(*items)
+	*items = append(*items, t)
+}
+
+func (items *scheduledItems) Pop() interface{} {
+	old := *items
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*items = old[:n-1]
+	return t
+}
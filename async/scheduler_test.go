@@ -0,0 +1,139 @@
+package async_test
+
+import (
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+
+	var tasks chan interface{}
+	var s *Scheduler
+
+	BeforeEach(func() {
+		tasks = make(chan interface{}, 10)
+
+		var err error
+		s, err = NewScheduler(tasks)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	It("requires a task channel", func() {
+		_, err := NewScheduler(nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("sends tasks once they are due, in order of due time", func(done Done) {
+		start := time.Now()
+
+		_, err := s.After(40*time.Millisecond, "later")
+		Expect(err).To(BeNil())
+		_, err = s.At(start.Add(20*time.Millisecond), "sooner")
+		Expect(err).To(BeNil())
+		_, err = s.After(-time.Second, "now")
+		Expect(err).To(BeNil())
+
+		Eventually(tasks).Should(Receive(Equal("now")))
+		Eventually(tasks).Should(Receive(Equal("sooner")))
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+		Eventually(tasks).Should(Receive(Equal("later")))
+		Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+
+		Expect(s.Len()).To(Equal(0))
+
+		close(done)
+	}, 3) // timeout
+
+	It("dispatches tasks due at the same time in the order they were scheduled", func(done Done) {
+		due := time.Now().Add(10 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			_, err := s.At(due, i)
+			Expect(err).To(BeNil())
+		}
+
+		for i := 0; i < 5; i++ {
+			Eventually(tasks).Should(Receive(Equal(i)))
+		}
+
+		close(done)
+	}, 3) // timeout
+
+	It("supports cancellation by handle", func(done Done) {
+		first, err := s.After(10*time.Millisecond, "first")
+		Expect(err).To(BeNil())
+		second, err := s.After(20*time.Millisecond, "second")
+		Expect(err).To(BeNil())
+
+		Expect(first.Task()).To(Equal("first"))
+		Expect(first.Due()).To(BeTemporally("<", second.Due()))
+
+		Expect(first.Cancel()).To(BeTrue())
+		Expect(first.Cancel()).To(BeFalse())
+
+		Eventually(tasks).Should(Receive(Equal("second")))
+		Expect(second.Cancel()).To(BeFalse()) // already dispatched
+		Consistently(tasks, 30*time.Millisecond).ShouldNot(Receive())
+
+		close(done)
+	}, 3) // timeout
+
+	It("scales to many pending tasks", func(done Done) {
+		handles := make([]*ScheduledTask, 200000)
+		for i := range handles {
+			var err error
+			handles[i], err = s.After(time.Hour+time.Duration(i), i)
+			Expect(err).To(BeNil())
+		}
+		Expect(s.Len()).To(Equal(len(handles)))
+
+		for i := 0; i < len(handles); i += 2 {
+			Expect(handles[i].Cancel()).To(BeTrue())
+		}
+		Expect(s.Len()).To(Equal(len(handles) / 2))
+
+		_, err := s.After(0, "now")
+		Expect(err).To(BeNil())
+		Eventually(tasks).Should(Receive(Equal("now")))
+
+		close(done)
+	}, 10) // timeout
+
+	It("discards pending tasks when stopped", func() {
+		handle, err := s.After(time.Hour, "task")
+		Expect(err).To(BeNil())
+
+		s.Stop()
+		Expect(s.Len()).To(Equal(0))
+		Expect(handle.Cancel()).To(BeFalse())
+
+		_, err = s.After(0, "task")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("feeds a WorkerPool", func(done Done) {
+		handled := make(chan interface{}, 1)
+
+		pool, err := NewWorkerPool(tasks, func(task interface{}) {
+			handled <- task
+		})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		_, err = s.After(5*time.Millisecond, "task")
+		Expect(err).To(BeNil())
+		Eventually(handled).Should(Receive(Equal("task")))
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,176 @@
+package async
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Semaphore is a weighted semaphore: it bounds the combined weight of the holders of a scarce resource, where each
+// holder acquires a weight according to its cost (e.g. a large job might acquire 4 units, and a small job 1).
+//
+// Waiters are served in FIFO order: a waiter that cannot be satisfied blocks the waiters behind it, even if they could
+// be, so that heavy waiters are not starved by light ones.
+// THREAD-SAFETY: the Semaphore is thread-safe.
+type Semaphore struct {
+	capacity int
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	held    int
+	waiters list.List // of *semaphoreWaiter
+}
+
+type semaphoreWaiter struct {
+	weight int
+	ready  chan struct{} // closed once the weight has been acquired on the waiter's behalf
+}
+
+// NewSemaphore returns a Semaphore with the provided capacity (the maximum combined weight of the holders).
+// NewSemaphore will return an error if 'capacity' is not positive.
+func NewSemaphore(capacity int) (*Semaphore, error) {
+
+	if capacity < 1 {
+		return nil, fmt.Errorf("capacity must be positive (%d)", capacity)
+	}
+
+	return &Semaphore{
+		capacity: capacity,
+		mutex:    sync.Mutex{}}, nil
+}
+
+// Acquire blocks until 'weight' units are available (acquiring them), or until the context is done, in which case the
+// context's error is returned and nothing is acquired. Acquire returns an error immediately if 'weight' is not
+// positive, or exceeds the semaphore's capacity.
+func (s *Semaphore) Acquire(ctx context.Context, weight int) error {
+
+	if err := s.validate(weight); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+
+	if s.waiters.Len() == 0 && s.held+weight <= s.capacity {
+		s.held += weight
+		s.mutex.Unlock()
+		return nil
+	}
+
+	w := &semaphoreWaiter{weight: weight, ready: make(chan struct{})}
+	element := s.waiters.PushBack(w)
+
+	s.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+
+	case <-ctx.Done():
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		select {
+		case <-w.ready:
+			// acquired concurrently with the context being done; give it back
+			s.held -= weight
+		default:
+			s.waiters.Remove(element)
+		}
+
+		s.notifyWaiters() // the waiters behind this one may now be satisfiable
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires 'weight' units and returns true if they are available now (and no one is waiting), otherwise
+// TryAcquire returns false.
+func (s *Semaphore) TryAcquire(weight int) bool {
+
+	if s.validate(weight) != nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.waiters.Len() == 0 && s.held+weight <= s.capacity {
+		s.held += weight
+		return true
+	}
+
+	return false
+}
+
+// Release releases 'weight' units, which must have been acquired. Release panics if more units are released than are
+// held.
+func (s *Semaphore) Release(weight int) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if weight < 0 || weight > s.held {
+		panic(fmt.Sprintf("semaphore: released %d units, but only %d are held", weight, s.held))
+	}
+
+	s.held -= weight
+	s.notifyWaiters()
+}
+
+// notifyWaiters grants the waiters at the head of the queue their weight, for as long as it is available; the caller
+// must hold the mutex.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(*semaphoreWaiter)
+		if s.held+w.weight > s.capacity {
+			return
+		}
+
+		s.held += w.weight
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+func (s *Semaphore) validate(weight int) error {
+
+	if weight < 1 {
+		return fmt.Errorf("weight must be positive (%d)", weight)
+	}
+
+	if weight > s.capacity {
+		return fmt.Errorf("weight (%d) exceeds the semaphore's capacity (%d)", weight, s.capacity)
+	}
+
+	return nil
+}
+
+// Capacity returns the capacity of the semaphore.
+func (s *Semaphore) Capacity() int {
+	return s.capacity
+}
+
+// Held returns the number of units currently acquired.
+func (s *Semaphore) Held() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.held
+}
+
+func (s *Semaphore) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return fmt.Sprintf("&Semaphore{capacity:%d held:%d waiters:%d}", s.capacity, s.held, s.waiters.Len())
+}
@@ -0,0 +1,109 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Semaphore", func() {
+
+	var s *Semaphore
+
+	BeforeEach(func() {
+		var err error
+		s, err = NewSemaphore(4)
+		Expect(err).To(BeNil())
+	})
+
+	It("is a Stringer", func() {
+		var _ fmt.Stringer = (*Semaphore)(nil)
+		Expect(fmt.Sprintf("%v", s)).To(ContainSubstring("Semaphore"))
+	})
+
+	It("requires a positive capacity", func() {
+		_, err := NewSemaphore(0)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("bounds the combined weight of the holders", func() {
+		Expect(s.Capacity()).To(Equal(4))
+
+		Expect(s.TryAcquire(3)).To(BeTrue())
+		Expect(s.TryAcquire(2)).To(BeFalse())
+		Expect(s.TryAcquire(1)).To(BeTrue())
+		Expect(s.Held()).To(Equal(4))
+
+		s.Release(3)
+		Expect(s.TryAcquire(2)).To(BeTrue())
+		Expect(s.Held()).To(Equal(3))
+	})
+
+	It("rejects invalid weights", func() {
+		Expect(s.Acquire(context.Background(), 0)).NotTo(Succeed())
+		Expect(s.Acquire(context.Background(), 5)).NotTo(Succeed()) // would never succeed
+		Expect(s.TryAcquire(5)).To(BeFalse())
+
+		Expect(func() { s.Release(1) }).To(Panic())
+	})
+
+	It("blocks until the weight is available, serving waiters in order", func(done Done) {
+		Expect(s.Acquire(context.Background(), 4)).To(Succeed())
+
+		acquired := make(chan int, 2)
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Acquire(context.Background(), 3)).To(Succeed())
+			acquired <- 3
+		}()
+		Eventually(s.String).Should(ContainSubstring("waiters:1"))
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Acquire(context.Background(), 1)).To(Succeed())
+			acquired <- 1
+		}()
+		Eventually(s.String).Should(ContainSubstring("waiters:2"))
+
+		s.Release(2)
+		Consistently(acquired, 20*time.Millisecond).ShouldNot(Receive()) // the heavy waiter is first in line
+		Expect(s.TryAcquire(1)).To(BeFalse())
+
+		s.Release(2)
+		Eventually(func() int { return len(acquired) }).Should(Equal(2))
+		Expect(s.Held()).To(Equal(4))
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops waiting once the context is done, without blocking the waiters behind", func(done Done) {
+		Expect(s.Acquire(context.Background(), 2)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			result <- s.Acquire(ctx, 4)
+		}()
+		Eventually(s.String).Should(ContainSubstring("waiters:1"))
+
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Acquire(context.Background(), 2)).To(Succeed())
+			close(acquired)
+		}()
+		Eventually(s.String).Should(ContainSubstring("waiters:2"))
+
+		cancel()
+		Eventually(result).Should(Receive(Equal(context.Canceled)))
+		Eventually(acquired).Should(BeClosed())
+		Expect(s.Held()).To(Equal(4))
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,76 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// ShutdownReport describes the outcome of WorkerPool.Shutdown.
+type ShutdownReport struct {
+	Abandoned bool // true if the context was done before the drain completed, and the pool was abandoned
+	Queued    int  // number of tasks left in the task channel (at the time of abandonment, if abandoned)
+	InFlight  int  // number of tasks still running when the pool was abandoned (their contexts were cancelled)
+}
+
+// Undone returns the number of tasks that were left undone: those never run, plus those interrupted by abandonment.
+func (r ShutdownReport) Undone() int {
+	return r.Queued + r.InFlight
+}
+
+// Shutdown gracefully stops the pool: the pool stops accepting work (Submit, Add and Autoscale return errors, and
+// autoscaling is stopped), and the workers drain the task channel, exiting once it is empty. Shutdown blocks until all
+// workers have stopped, or until the context is done, in which case the pool is abandoned (see Abandon), and the
+// context's error is returned; use Wait to wait for the abandoned workers to finish their in-flight tasks.
+//
+// The returned report counts the tasks that were left undone. Shutdown does not close the (caller-owned) task channel;
+// producers should stop sending before Shutdown is called, as tasks sent afterwards may not be run.
+//
+// A paused pool (see Pause) is resumed, so that it can drain.
+//
+// Shutdown returns an error if the pool has already been shut down or abandoned.
+func (p *WorkerPool) Shutdown(ctx context.Context) (ShutdownReport, error) {
+
+	p.mutex.Lock()
+
+	if p.isAbandoned {
+		p.mutex.Unlock()
+		return ShutdownReport{}, fmt.Errorf("tried to shut down a pool that has been abandoned")
+	}
+
+	if p.isShutdown {
+		p.mutex.Unlock()
+		return ShutdownReport{}, fmt.Errorf("tried to shut down a pool that has already been shut down")
+	}
+
+	p.isShutdown = true
+	if p.autoscaler != nil {
+		close(p.autoscaler.stop)
+		p.autoscaler = nil
+	}
+	close(p.drain)
+	p.gate.set(false) // a paused pool must resume in order to drain
+
+	p.mutex.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.waitGroup.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return ShutdownReport{Queued: len(p.tasks)}, nil
+
+	case <-ctx.Done():
+		report := ShutdownReport{
+			Abandoned: true,
+			Queued:    len(p.tasks),
+			InFlight:  int(atomic.LoadInt32(&p.busy))}
+
+		p.Abandon()
+
+		return report, ctx.Err()
+	}
+}
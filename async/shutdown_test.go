@@ -0,0 +1,107 @@
+package async_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shutdown", func() {
+
+	var callCount uint32
+	var tasks chan interface{}
+	var release chan struct{}
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		callCount = 0
+		tasks = make(chan interface{}, 8)
+		release = make(chan struct{})
+
+		var err error
+		pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(ctx context.Context, task interface{}) error {
+			if task == "block" {
+				select {
+				case <-release:
+				case <-ctx.Done():
+				}
+			}
+			atomic.AddUint32(&callCount, 1)
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+	})
+
+	It("drains the task channel, then returns once the workers have stopped", func(done Done) {
+		Expect(pool.Add(2)).To(BeNil())
+
+		for i := 0; i < 5; i++ {
+			tasks <- i
+		}
+
+		report, err := pool.Shutdown(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Abandoned).To(BeFalse())
+		Expect(report.Undone()).To(Equal(0))
+		Expect(atomic.LoadUint32(&callCount)).To(Equal(uint32(5)))
+
+		pool.Wait() // must not deadlock
+
+		close(done)
+	}, 3) // timeout
+
+	It("stops accepting work", func() {
+		_, err := pool.Shutdown(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(pool.Add(1)).To(HaveOccurred())
+		Expect(pool.Autoscale(AutoscaleConfig{MaxWorkers: 1, Interval: time.Second})).To(HaveOccurred())
+
+		_, err = pool.Submit(func() (interface{}, error) { return nil, nil }).Get()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("abandons the pool and reports the undone tasks if the context is done first", func(done Done) {
+		Expect(pool.Add(1)).To(BeNil())
+
+		tasks <- "block"
+		tasks <- 1
+		tasks <- 2
+
+		Eventually(func() int { return len(tasks) }).Should(Equal(2)) // the worker has picked up the blocking task
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(report.Abandoned).To(BeTrue())
+		Expect(report.InFlight).To(Equal(1))
+		Expect(report.Queued).To(Equal(2))
+		Expect(report.Undone()).To(Equal(3))
+
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("returns an error if called more than once, or on an abandoned pool", func() {
+		_, err := pool.Shutdown(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = pool.Shutdown(context.Background())
+		Expect(err).To(HaveOccurred())
+
+		abandonedPool, err := NewWorkerPool(tasks, func(interface{}) {})
+		Expect(err).To(BeNil())
+		abandonedPool.Abandon()
+
+		_, err = abandonedPool.Shutdown(context.Background())
+		Expect(err).To(HaveOccurred())
+	})
+})
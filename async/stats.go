@@ -0,0 +1,211 @@
+package async
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Timestamped is an optional interface for tasks that record the time at which they were enqueued; it allows the time
+// a task spent waiting in the task channel to be measured. Callables submitted via WorkerPool.Submit are timestamped
+// automatically.
+type Timestamped interface {
+	Timestamp() time.Time
+}
+
+// waitTime returns how long a task waited to be started, or zero if the task is not Timestamped.
+func waitTime(task interface{}, started time.Time) time.Duration {
+	if t, ok := task.(Timestamped); ok {
+		if wait := started.Sub(t.Timestamp()); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// StatsHook receives per-task execution events, e.g. for exporting to a metrics system (see Options.StatsHook).
+// Hook methods are called on the worker goroutine, so implementations should be fast and must be thread-safe.
+type StatsHook interface {
+	// TaskStarted is called as a worker starts a task; 'wait' is the time the task spent queued, or zero if unknown
+	// (see Timestamped).
+	TaskStarted(task interface{}, wait time.Duration)
+
+	// TaskFinished is called as a worker finishes a task; 'err' is the task's error, if any (a *PanicError if the task
+	// panicked and the panic was recovered).
+	TaskFinished(task interface{}, run time.Duration, err error)
+}
+
+// HistogramBounds are the upper bounds of the buckets of the latency histograms in Stats (the final bucket is
+// unbounded).
+var HistogramBounds = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	1 * time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	1 * time.Second, 2500 * time.Millisecond, 5 * time.Second,
+	10 * time.Second, 30 * time.Second, 60 * time.Second,
+}
+
+// Histogram is a snapshot of a latency histogram. Counts[i] is the number of observations no greater than
+// HistogramBounds[i] (and greater than the previous bound); the final element of Counts is the number of observations
+// greater than the last bound.
+type Histogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean returns the mean of the observations, or zero if there are none.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an estimate of the q-quantile (0 <= q <= 1) of the observations: the upper bound of the bucket in
+// which the quantile falls (or the last bound, for the unbounded bucket). Quantile returns zero if there are no
+// observations.
+func (h Histogram) Quantile(q float64) time.Duration {
+
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(h.Count))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		if cumulative >= rank {
+			if i < len(HistogramBounds) {
+				return HistogramBounds[i]
+			}
+			break
+		}
+	}
+
+	return HistogramBounds[len(HistogramBounds)-1]
+}
+
+// histogram is a thread-safe latency histogram.
+type histogram struct {
+	counts []uint64 // atomic
+	count  uint64   // atomic
+	sum    int64    // atomic; nanoseconds
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(HistogramBounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+
+	i := 0
+	for i < len(HistogramBounds) && d > HistogramBounds[i] {
+		i++
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+
+	snapshot := Histogram{
+		Counts: make([]uint64, len(h.counts)),
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum))}
+
+	for i := range h.counts {
+		snapshot.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return snapshot
+}
+
+// Stats is a snapshot of the statistics of a WorkerPool (see WorkerPool.Stats). The counters cover the lifetime of the
+// pool. As the snapshot is not taken atomically, the values may be slightly inconsistent with one another.
+type Stats struct {
+	Workers int // number of workers in the pool
+	Busy    int // number of workers running a task
+	Idle    int // number of workers waiting for a task
+	Queued  int // number of tasks waiting in the task channel
+
+	Started   uint64 // number of tasks started
+	Completed uint64 // number of tasks that finished without error
+	Failed    uint64 // number of tasks that finished with an error (including those skipped, see Deadliner)
+	Panicked  uint64 // number of tasks that panicked (when recovered, see PanicPolicy)
+
+	WaitTime Histogram // time tasks spent queued; only Timestamped tasks are observed
+	RunTime  Histogram // time tasks spent running
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("&Stats{workers:%d busy:%d queued:%d started:%d completed:%d failed:%d panicked:%d}",
+		s.Workers, s.Busy, s.Queued, s.Started, s.Completed, s.Failed, s.Panicked)
+}
+
+// poolStats holds the counters behind WorkerPool.Stats.
+type poolStats struct {
+	// 64-bit atomics first, for alignment on 32-bit platforms
+	started   uint64
+	completed uint64
+	failed    uint64
+	panicked  uint64
+
+	waitTime *histogram
+	runTime  *histogram
+}
+
+func newPoolStats() *poolStats {
+	return &poolStats{
+		waitTime: newHistogram(),
+		runTime:  newHistogram()}
+}
+
+func (s *poolStats) taskStarted(wait time.Duration) {
+	atomic.AddUint64(&s.started, 1)
+	if wait > 0 {
+		s.waitTime.observe(wait)
+	}
+}
+
+func (s *poolStats) taskFinished(run time.Duration, err error) {
+
+	s.runTime.observe(run)
+
+	switch err.(type) {
+	case nil:
+		atomic.AddUint64(&s.completed, 1)
+	case *PanicError:
+		atomic.AddUint64(&s.panicked, 1)
+	default:
+		atomic.AddUint64(&s.failed, 1)
+	}
+}
+
+// Stats returns a snapshot of the pool's statistics.
+func (p *WorkerPool) Stats() Stats {
+
+	workers := p.Size()
+	busy := int(atomic.LoadInt32(&p.busy))
+
+	return Stats{
+		Workers:   workers,
+		Busy:      busy,
+		Idle:      maxInt(workers-busy, 0),
+		Queued:    len(p.tasks),
+		Started:   atomic.LoadUint64(&p.stats.started),
+		Completed: atomic.LoadUint64(&p.stats.completed),
+		Failed:    atomic.LoadUint64(&p.stats.failed),
+		Panicked:  atomic.LoadUint64(&p.stats.panicked),
+		WaitTime:  p.stats.waitTime.snapshot(),
+		RunTime:   p.stats.runTime.snapshot()}
+}
@@ -0,0 +1,126 @@
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingHook struct {
+	mutex    sync.Mutex
+	started  int
+	finished []error
+}
+
+func (h *recordingHook) TaskStarted(interface{}, time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.started++
+}
+
+func (h *recordingHook) TaskFinished(_ interface{}, _ time.Duration, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.finished = append(h.finished, err)
+}
+
+func (h *recordingHook) numFinished() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.finished)
+}
+
+var _ = Describe("Stats", func() {
+
+	var tasks chan interface{}
+	var hook *recordingHook
+	var pool *WorkerPool
+
+	BeforeEach(func() {
+		tasks = make(chan interface{}, 8)
+		hook = &recordingHook{}
+
+		var err error
+		pool, err = NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+			switch task {
+			case "fail":
+				return fmt.Errorf("failed")
+			case "panic":
+				panic("boom")
+			}
+			return nil
+		}, &Options{PanicPolicy: PanicRestart, StatsHook: hook})
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func(done Done) {
+		pool.Abandon()
+		pool.Wait()
+		close(done)
+	}, 3) // timeout
+
+	It("counts tasks by outcome and observes latencies", func() {
+		Expect(pool.Add(2)).To(BeNil())
+
+		tasks <- 1
+		tasks <- "fail"
+		tasks <- "panic"
+		_, err := pool.Submit(func() (interface{}, error) { return nil, nil }).Get()
+		Expect(err).To(BeNil())
+
+		Eventually(func() uint64 { return pool.Stats().RunTime.Count }).Should(Equal(uint64(4)))
+
+		stats := pool.Stats()
+		Expect(stats.Workers).To(Equal(2))
+		Expect(stats.Busy + stats.Idle).To(Equal(2))
+		Expect(stats.Started).To(Equal(uint64(4)))
+		Expect(stats.Completed).To(Equal(uint64(2)))
+		Expect(stats.Failed).To(Equal(uint64(1)))
+		Expect(stats.Panicked).To(Equal(uint64(1)))
+		Expect(stats.WaitTime.Count).To(Equal(uint64(1))) // only the submitted Callable is Timestamped
+		Expect(stats.String()).To(ContainSubstring("panicked:1"))
+	})
+
+	It("reports the queue length", func() {
+		tasks <- 1
+		tasks <- 2
+		Expect(pool.Stats().Queued).To(Equal(2))
+	})
+
+	It("calls the StatsHook for each task", func() {
+		Expect(pool.Add(1)).To(BeNil())
+
+		tasks <- 1
+		tasks <- "panic"
+
+		Eventually(hook.numFinished).Should(Equal(2))
+
+		hook.mutex.Lock()
+		defer hook.mutex.Unlock()
+		Expect(hook.started).To(Equal(2))
+		Expect(hook.finished[0]).To(BeNil())
+		Expect(hook.finished[1]).To(BeAssignableToTypeOf(&PanicError{}))
+	})
+})
+
+var _ = Describe("Histogram", func() {
+	It("computes the mean and quantiles", func() {
+		Expect(Histogram{}.Mean()).To(Equal(time.Duration(0)))
+		Expect(Histogram{}.Quantile(0.5)).To(Equal(time.Duration(0)))
+
+		counts := make([]uint64, len(HistogramBounds)+1)
+		counts[0] = 9                    // <= 100us
+		counts[len(HistogramBounds)] = 1 // > last bound
+		h := Histogram{Counts: counts, Count: 10, Sum: 10 * time.Millisecond}
+
+		Expect(h.Mean()).To(Equal(time.Millisecond))
+		Expect(h.Quantile(0.5)).To(Equal(HistogramBounds[0]))
+		Expect(h.Quantile(1)).To(Equal(HistogramBounds[len(HistogramBounds)-1]))
+	})
+})
@@ -0,0 +1,61 @@
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// StopHandle tracks a set of workers that have been told to stop (see WorkerPool.Shrink and WorkerPool.Halt), so
+// that the caller can wait for exactly those workers to exit.
+// THREAD-SAFETY: the StopHandle is thread-safe.
+type StopHandle struct {
+	workers  []*Worker
+	done     chan struct{} // closed once all the workers have stopped; created on demand
+	doneOnce sync.Once
+}
+
+func newStopHandle(workers []*Worker) *StopHandle {
+	return &StopHandle{workers: workers}
+}
+
+// Len returns the number of workers tracked by the handle.
+func (h *StopHandle) Len() int {
+	return len(h.workers)
+}
+
+// Wait blocks until all the workers have stopped, or until the context is done, in which case the context's error is
+// returned (the workers continue to stop regardless).
+func (h *StopHandle) Wait(ctx context.Context) error {
+
+	for _, w := range h.workers {
+		select {
+		case <-w.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Done returns a channel that is closed once all the workers have stopped.
+func (h *StopHandle) Done() <-chan struct{} {
+
+	h.doneOnce.Do(func() {
+		h.done = make(chan struct{})
+
+		go func() {
+			for _, w := range h.workers {
+				<-w.Done()
+			}
+			close(h.done)
+		}()
+	})
+
+	return h.done
+}
+
+func (h *StopHandle) String() string {
+	return fmt.Sprintf("&StopHandle{numWorkers:%d}", len(h.workers))
+}
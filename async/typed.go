@@ -0,0 +1,402 @@
+//go:build go1.18
+// +build go1.18
+
+package async
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// forward copies tasks from a typed channel to an untyped one, closing the latter once the former is closed. forward
+// returns early, without closing the untyped channel, once 'stop' is closed (i.e. the typed wrapper was abandoned), or
+// once 'done' is closed (i.e. the untyped consumer has stopped, e.g. per PanicStop); a task that the consumer can no
+// longer receive is dropped.
+func forward[T any](from <-chan T, to chan<- interface{}, stop <-chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case task, ok := <-from:
+			if !ok {
+				close(to)
+				return
+			}
+
+			select {
+			case to <- task:
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+
+		case <-stop:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// adaptTypedHandler wraps a typed, context-aware task handler as a ContextHandler.
+func adaptTypedHandler[T any](handleTask func(context.Context, T) error) ContextHandler {
+	return func(ctx context.Context, task interface{}) error {
+		typed, _ := task.(T) // the zero value of T on a nil task (i.e. if T is an interface type)
+		return handleTask(ctx, typed)
+	}
+}
+
+// TypedWorker is a type-safe Worker that receives tasks of type T. See Worker for the semantics.
+type TypedWorker[T any] struct {
+	worker   *Worker
+	stop     chan struct{} // stops the forwarder
+	stopOnce sync.Once
+}
+
+// NewTypedWorker creates, starts, and returns a new TypedWorker; see NewWorker.
+// NewTypedWorker will return an error if 'tasks' or 'handleTask' are nil.
+func NewTypedWorker[T any](tasks chan T, handleTask func(T), waitGroup *sync.WaitGroup) (*TypedWorker[T], error) {
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	return NewTypedWorkerWithContext(context.Background(), tasks, func(_ context.Context, task T) error {
+		handleTask(task)
+		return nil
+	}, waitGroup, nil)
+}
+
+// NewTypedWorkerWithContext creates, starts, and returns a new TypedWorker with a context-aware handler; see
+// NewWorkerWithContext.
+// NewTypedWorkerWithContext will return an error if 'ctx', 'tasks' or 'handleTask' are nil. 'opts' may be nil.
+func NewTypedWorkerWithContext[T any](ctx context.Context, tasks chan T, handleTask func(context.Context, T) error, waitGroup *sync.WaitGroup, opts *Options) (*TypedWorker[T], error) {
+
+	if tasks == nil {
+		return nil, fmt.Errorf("tasks channel cannot be nil")
+	}
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	untyped := make(chan interface{})
+
+	worker, err := newWorker(ctx, untyped, adaptTypedHandler(handleTask), waitGroup, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &TypedWorker[T]{
+		worker: worker,
+		stop:   make(chan struct{})}
+
+	go forward(tasks, untyped, w.stop, worker.Done())
+
+	return w, nil
+}
+
+// Abandon instructs the worker to stop in the near future; see Worker.Abandon.
+func (w *TypedWorker[T]) Abandon() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	w.worker.Abandon()
+}
+
+// Wait is a blocking call that waits for the worker to stop; see Worker.Wait.
+// IMPORTANT: You must have closed the task channel and/or called Abandon() prior to calling Wait, otherwise a
+// deadlock will occur.
+func (w *TypedWorker[T]) Wait() {
+	w.worker.Wait()
+}
+
+// TypedWorkerPool is a type-safe WorkerPool whose workers receive tasks of type T. See WorkerPool for the semantics.
+// THREAD-SAFETY: the TypedWorkerPool is thread-safe.
+type TypedWorkerPool[T any] struct {
+	pool     *WorkerPool
+	stop     chan struct{} // stops the forwarder
+	stopOnce sync.Once
+}
+
+// NewTypedWorkerPool returns a TypedWorkerPool; see NewWorkerPool. The pool is initially empty.
+// NewTypedWorkerPool will return an error if 'tasks' or 'handleTask' are nil.
+func NewTypedWorkerPool[T any](tasks chan T, handleTask func(T)) (*TypedWorkerPool[T], error) {
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	return NewTypedWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task T) error {
+		handleTask(task)
+		return nil
+	}, nil)
+}
+
+// NewTypedWorkerPoolWithContext returns a TypedWorkerPool with a context-aware handler; see NewWorkerPoolWithContext.
+// NewTypedWorkerPoolWithContext will return an error if 'ctx', 'tasks' or 'handleTask' are nil. 'opts' may be nil.
+func NewTypedWorkerPoolWithContext[T any](ctx context.Context, tasks chan T, handleTask func(context.Context, T) error, opts *Options) (*TypedWorkerPool[T], error) {
+
+	if tasks == nil {
+		return nil, fmt.Errorf("tasks channel cannot be nil")
+	}
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	untyped := make(chan interface{})
+
+	pool, err := newWorkerPool(ctx, untyped, adaptTypedHandler(handleTask), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &TypedWorkerPool[T]{
+		pool: pool,
+		stop: make(chan struct{})}
+
+	go forward(tasks, untyped, p.stop, pool.ctx.Done())
+
+	return p, nil
+}
+
+// Add creates, starts, and adds to the pool a number of workers equal to count; see WorkerPool.Add.
+func (p *TypedWorkerPool[T]) Add(count int) error {
+	return p.pool.Add(count)
+}
+
+// Remove stops and removes from the pool a number of workers equal to count; see WorkerPool.Remove.
+func (p *TypedWorkerPool[T]) Remove(count int) error {
+	return p.pool.Remove(count)
+}
+
+// Shrink is like Remove, but additionally returns a StopHandle for the removed workers; see WorkerPool.Shrink.
+func (p *TypedWorkerPool[T]) Shrink(count int) (*StopHandle, error) {
+	return p.pool.Shrink(count)
+}
+
+// Pause stops the pool's workers from starting tasks until Resume is called; see WorkerPool.Pause.
+func (p *TypedWorkerPool[T]) Pause() {
+	p.pool.Pause()
+}
+
+// Resume resumes a paused pool; see WorkerPool.Resume.
+func (p *TypedWorkerPool[T]) Resume() {
+	p.pool.Resume()
+}
+
+// IsPaused returns true if the pool is paused; see WorkerPool.IsPaused.
+func (p *TypedWorkerPool[T]) IsPaused() bool {
+	return p.pool.IsPaused()
+}
+
+// Size returns the number of workers in the pool.
+func (p *TypedWorkerPool[T]) Size() int {
+	return p.pool.Size()
+}
+
+// Abandon instructs all workers in the pool to stop in the near future; see WorkerPool.Abandon.
+func (p *TypedWorkerPool[T]) Abandon() {
+	p.Halt()
+}
+
+// Halt is like Abandon, but additionally returns a StopHandle with which to wait for the workers; see WorkerPool.Halt.
+func (p *TypedWorkerPool[T]) Halt() *StopHandle {
+	p.stopOnce.Do(func() { close(p.stop) })
+	return p.pool.Halt()
+}
+
+// Wait is a blocking call that waits for all workers in the pool to stop; see WorkerPool.Wait.
+// IMPORTANT: You must have closed the task channel and/or called Abandon() prior to calling Wait, otherwise a
+// deadlock will occur.
+func (p *TypedWorkerPool[T]) Wait() {
+	p.pool.Wait()
+}
+
+func (p *TypedWorkerPool[T]) String() string {
+	return fmt.Sprintf("&TypedWorkerPool{numWorkers:%d}", p.Size())
+}
+
+// TypedFuture is a type-safe handle to the result of a task submitted to a TypedResultPool; see Future.
+type TypedFuture[R any] struct {
+	future *Future
+}
+
+// Done returns a channel that is closed once the result is available.
+func (f *TypedFuture[R]) Done() <-chan struct{} {
+	return f.future.Done()
+}
+
+// Get is a blocking call that waits for the result to become available; see Future.Get.
+func (f *TypedFuture[R]) Get() (R, error) {
+	return typedResult[R](f.future.Get())
+}
+
+// GetWithContext is a blocking call that waits for the result to become available or for the context to be done,
+// whichever comes first; see Future.GetWithContext.
+func (f *TypedFuture[R]) GetWithContext(ctx context.Context) (R, error) {
+	return typedResult[R](f.future.GetWithContext(ctx))
+}
+
+func typedResult[R any](value interface{}, err error) (R, error) {
+	result, _ := value.(R) // the zero value of R on a nil value (e.g. on error)
+	return result, err
+}
+
+// TypedResultPool is a type-safe pool of workers that run tasks of type T to produce results of type R. Tasks are
+// submitted via Submit, which returns a TypedFuture for the result. The pool owns its task channel; use Shutdown or
+// Abandon to stop the pool.
+// THREAD-SAFETY: the TypedResultPool is thread-safe.
+type TypedResultPool[T any, R any] struct {
+	pool       *WorkerPool
+	handleTask func(context.Context, T) (R, error)
+}
+
+// NewTypedResultPool returns a TypedResultPool whose workers run submitted tasks by calling handleTask(); the context
+// passed to handleTask is derived from 'ctx' and is cancelled when the pool is abandoned (see
+// NewWorkerPoolWithContext). 'queueSize' is the buffer size of the pool's task channel. The pool is initially empty.
+// NewTypedResultPool will return an error if 'ctx' or 'handleTask' are nil, or 'queueSize' is negative. 'opts' may be
+// nil.
+func NewTypedResultPool[T any, R any](ctx context.Context, queueSize int, handleTask func(context.Context, T) (R, error), opts *Options) (*TypedResultPool[T, R], error) {
+
+	if queueSize < 0 {
+		return nil, fmt.Errorf("queueSize cannot be negative (%d)", queueSize)
+	}
+
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	// Only Callables are sent to the pool's channel, so the pool's handler is never called
+	pool, err := newWorkerPool(ctx, make(chan interface{}, queueSize), func(context.Context, interface{}) error {
+		return nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedResultPool[T, R]{
+		pool:       pool,
+		handleTask: handleTask}, nil
+}
+
+// Submit queues a task and returns a TypedFuture for its result; see WorkerPool.SubmitWithContext.
+func (p *TypedResultPool[T, R]) Submit(task T) *TypedFuture[R] {
+	return &TypedFuture[R]{p.pool.SubmitWithContext(func(ctx context.Context) (interface{}, error) {
+		return p.handleTask(ctx, task)
+	})}
+}
+
+// Add creates, starts, and adds to the pool a number of workers equal to count; see WorkerPool.Add.
+func (p *TypedResultPool[T, R]) Add(count int) error {
+	return p.pool.Add(count)
+}
+
+// Remove stops and removes from the pool a number of workers equal to count; see WorkerPool.Remove.
+func (p *TypedResultPool[T, R]) Remove(count int) error {
+	return p.pool.Remove(count)
+}
+
+// Shrink is like Remove, but additionally returns a StopHandle for the removed workers; see WorkerPool.Shrink.
+func (p *TypedResultPool[T, R]) Shrink(count int) (*StopHandle, error) {
+	return p.pool.Shrink(count)
+}
+
+// Pause stops the pool's workers from starting tasks until Resume is called; see WorkerPool.Pause.
+func (p *TypedResultPool[T, R]) Pause() {
+	p.pool.Pause()
+}
+
+// Resume resumes a paused pool; see WorkerPool.Resume.
+func (p *TypedResultPool[T, R]) Resume() {
+	p.pool.Resume()
+}
+
+// IsPaused returns true if the pool is paused; see WorkerPool.IsPaused.
+func (p *TypedResultPool[T, R]) IsPaused() bool {
+	return p.pool.IsPaused()
+}
+
+// Size returns the number of workers in the pool.
+func (p *TypedResultPool[T, R]) Size() int {
+	return p.pool.Size()
+}
+
+// Abandon instructs all workers in the pool to stop in the near future; see WorkerPool.Abandon.
+func (p *TypedResultPool[T, R]) Abandon() {
+	p.pool.Abandon()
+}
+
+// Halt is like Abandon, but additionally returns a StopHandle with which to wait for the workers; see WorkerPool.Halt.
+func (p *TypedResultPool[T, R]) Halt() *StopHandle {
+	return p.pool.Halt()
+}
+
+// Shutdown gracefully stops the pool, draining any queued tasks; see WorkerPool.Shutdown.
+func (p *TypedResultPool[T, R]) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	return p.pool.Shutdown(ctx)
+}
+
+// Wait is a blocking call that waits for all workers in the pool to stop; see WorkerPool.Wait.
+// IMPORTANT: You must have called Shutdown() or Abandon() prior to calling Wait, otherwise a deadlock will occur.
+func (p *TypedResultPool[T, R]) Wait() {
+	p.pool.Wait()
+}
+
+func (p *TypedResultPool[T, R]) String() string {
+	return fmt.Sprintf("&TypedResultPool{numWorkers:%d}", p.Size())
+}
+
+// TypedBatchWorker is a type-safe BatchWorker that receives tasks of type T, and handles them in batches of []T. See
+// BatchWorker for the semantics.
+type TypedBatchWorker[T any] struct {
+	worker   *BatchWorker
+	stop     chan struct{} // stops the forwarder
+	stopOnce sync.Once
+}
+
+// NewTypedBatchWorker creates, starts, and returns a new TypedBatchWorker; see NewBatchWorker.
+// NewTypedBatchWorker will return an error if 'ctx', 'tasks' or 'handleBatch' are nil, or if the config is invalid.
+func NewTypedBatchWorker[T any](ctx context.Context, tasks chan T, cfg BatchConfig, handleBatch func(context.Context, []T) error, waitGroup *sync.WaitGroup, opts *Options) (*TypedBatchWorker[T], error) {
+
+	if tasks == nil {
+		return nil, fmt.Errorf("tasks channel cannot be nil")
+	}
+
+	if handleBatch == nil {
+		return nil, fmt.Errorf("handleBatch func cannot be nil")
+	}
+
+	untyped := make(chan interface{})
+
+	worker, err := NewBatchWorker(ctx, untyped, cfg, func(ctx context.Context, batch []interface{}) error {
+		typed := make([]T, len(batch))
+		for i, task := range batch {
+			typed[i], _ = task.(T) // the zero value of T on a nil task
+		}
+		return handleBatch(ctx, typed)
+	}, waitGroup, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &TypedBatchWorker[T]{
+		worker: worker,
+		stop:   make(chan struct{})}
+
+	go forward(tasks, untyped, w.stop, worker.worker.Done()) // the collector stops along with its worker
+
+	return w, nil
+}
+
+// Abandon instructs the worker to stop in the near future, discarding any partial batch; see BatchWorker.Abandon.
+func (w *TypedBatchWorker[T]) Abandon() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	w.worker.Abandon()
+}
+
+// Wait is a blocking call that waits for the worker to stop; see BatchWorker.Wait.
+// IMPORTANT: You must have closed the task channel and/or called Abandon() prior to calling Wait, otherwise a
+// deadlock will occur.
+func (w *TypedBatchWorker[T]) Wait() {
+	w.worker.Wait()
+}
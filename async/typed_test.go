@@ -0,0 +1,276 @@
+//go:build go1.18
+// +build go1.18
+
+package async_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type typedTask struct {
+	value int
+}
+
+var _ = Describe("TypedWorker", func() {
+
+	It("requires a task channel and a handler func", func() {
+		_, err := NewTypedWorker[int](nil, func(int) {}, nil)
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewTypedWorker(make(chan int), nil, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("runs typed tasks until the task channel is closed", func(done Done) {
+		var sum int64
+		tasks := make(chan typedTask)
+
+		w, err := NewTypedWorker(tasks, func(task typedTask) {
+			atomic.AddInt64(&sum, int64(task.value))
+		}, nil)
+		Expect(err).To(BeNil())
+
+		tasks <- typedTask{1}
+		tasks <- typedTask{2}
+		close(tasks)
+		w.Wait()
+
+		Expect(atomic.LoadInt64(&sum)).To(Equal(int64(3)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("can be abandoned", func(done Done) {
+		tasks := make(chan int)
+
+		w, err := NewTypedWorker(tasks, func(int) {}, nil)
+		Expect(err).To(BeNil())
+
+		w.Abandon()
+		w.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("doesn't leak its forwarder once the worker stops on its own", func(done Done) {
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 50; i++ {
+			tasks := make(chan int)
+
+			w, err := NewTypedWorkerWithContext(context.Background(), tasks, func(context.Context, int) error {
+				panic("boom")
+			}, nil, &Options{PanicPolicy: PanicStop})
+			Expect(err).To(BeNil())
+
+			tasks <- 1 // stops the worker
+
+			select {
+			case tasks <- 2: // held by the forwarder, as the worker is stopping
+			case <-time.After(10 * time.Millisecond): // the forwarder has already returned
+			}
+
+			w.Wait()
+		}
+
+		Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+
+		close(done)
+	}, 3) // timeout
+})
+
+var _ = Describe("TypedWorkerPool", func() {
+
+	It("requires a task channel and a handler func", func() {
+		_, err := NewTypedWorkerPool[int](nil, func(int) {})
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewTypedWorkerPool(make(chan int), nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("supports Add, Remove, Size, and draining via close", func(done Done) {
+		var sum int64
+		tasks := make(chan typedTask, 4)
+
+		pool, err := NewTypedWorkerPool(tasks, func(task typedTask) {
+			atomic.AddInt64(&sum, int64(task.value))
+		})
+		Expect(err).To(BeNil())
+		Expect(fmt.Sprintf("%v", pool)).To(ContainSubstring("TypedWorkerPool"))
+
+		Expect(pool.Add(3)).To(BeNil())
+		Expect(pool.Remove(1)).To(BeNil())
+		Expect(pool.Size()).To(Equal(2))
+
+		for i := 1; i <= 4; i++ {
+			tasks <- typedTask{i}
+		}
+		close(tasks)
+		pool.Wait()
+
+		Expect(atomic.LoadInt64(&sum)).To(Equal(int64(10)))
+
+		close(done)
+	}, 3) // timeout
+
+	It("can be abandoned", func(done Done) {
+		tasks := make(chan int)
+
+		pool, err := NewTypedWorkerPoolWithContext(context.Background(), tasks, func(context.Context, int) error {
+			return nil
+		}, nil)
+		Expect(err).To(BeNil())
+		Expect(pool.Add(2)).To(BeNil())
+
+		pool.Abandon()
+		pool.Wait()
+
+		Expect(pool.Add(1)).To(HaveOccurred())
+
+		close(done)
+	}, 3) // timeout
+
+	It("passes nil tasks through when T is an interface type", func(done Done) {
+		tasks := make(chan error)
+		received := make(chan error, 1)
+
+		pool, err := NewTypedWorkerPool(tasks, func(task error) {
+			received <- task
+		})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(BeNil())
+
+		tasks <- nil
+		Eventually(received).Should(Receive(BeNil()))
+
+		close(tasks)
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
+
+var _ = Describe("TypedResultPool", func() {
+
+	It("requires a handler func and a non-negative queue size", func() {
+		_, err := NewTypedResultPool[int, int](context.Background(), 0, nil, nil)
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewTypedResultPool(context.Background(), -1, func(context.Context, int) (int, error) {
+			return 0, nil
+		}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns typed results and errors via TypedFutures", func(done Done) {
+		pool, err := NewTypedResultPool(context.Background(), 1, func(_ context.Context, task int) (string, error) {
+			if task < 0 {
+				return "", fmt.Errorf("negative")
+			}
+			return fmt.Sprintf("#%d", task), nil
+		}, nil)
+		Expect(err).To(BeNil())
+		Expect(pool.Add(2)).To(BeNil())
+
+		value, err := pool.Submit(42).Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("#42"))
+
+		f := pool.Submit(-1)
+		Eventually(f.Done()).Should(BeClosed())
+
+		value, err = f.GetWithContext(context.Background())
+		Expect(err).To(MatchError("negative"))
+		Expect(value).To(Equal(""))
+
+		_, err = pool.Shutdown(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("passes the per-task context to the handler", func(done Done) {
+		pool, err := NewTypedResultPool(context.Background(), 0, func(ctx context.Context, _ int) (interface{}, error) {
+			return WorkerValue(ctx), nil
+		}, &Options{
+			OnWorkerStart: func(uint64) (interface{}, error) { return "state", nil }})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(BeNil())
+
+		value, err := pool.Submit(1).Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("state"))
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
+
+var _ = Describe("TypedBatchWorker", func() {
+
+	It("requires a task channel and a handler func", func() {
+		cfg := BatchConfig{MaxSize: 2, MaxDelay: time.Second}
+
+		_, err := NewTypedBatchWorker[int](context.Background(), nil, cfg, func(context.Context, []int) error { return nil }, nil, nil)
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewTypedBatchWorker[int](context.Background(), make(chan int), cfg, nil, nil, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("handles typed batches, flushing when the task channel is closed", func(done Done) {
+		tasks := make(chan int)
+		var batches [][]int
+
+		w, err := NewTypedBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 2, MaxDelay: time.Minute}, func(_ context.Context, batch []int) error {
+			batches = append(batches, batch)
+			return nil
+		}, nil, nil)
+		Expect(err).To(BeNil())
+
+		for i := 1; i <= 3; i++ {
+			tasks <- i
+		}
+		close(tasks)
+		w.Wait()
+
+		Expect(batches).To(Equal([][]int{{1, 2}, {3}}))
+
+		close(done)
+	}, 3) // timeout
+
+	It("passes nil tasks through when T is an interface type", func(done Done) {
+		tasks := make(chan error)
+		var batches [][]error
+
+		w, err := NewTypedBatchWorker(context.Background(), tasks, BatchConfig{MaxSize: 2, MaxDelay: time.Minute}, func(_ context.Context, batch []error) error {
+			batches = append(batches, batch)
+			return nil
+		}, nil, nil)
+		Expect(err).To(BeNil())
+
+		tasks <- nil
+		tasks <- fmt.Errorf("task")
+		close(tasks)
+		w.Wait()
+
+		Expect(batches).To(HaveLen(1))
+		Expect(batches[0]).To(HaveLen(2))
+		Expect(batches[0][0]).To(BeNil())
+
+		close(done)
+	}, 3) // timeout
+})
@@ -0,0 +1,128 @@
+package async
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bit-mancer/go-util/config"
+)
+
+// WatchdogPolicy configures the detection of stuck tasks (see Options.Watchdog): a task that has been running for
+// longer than the threshold is reported, once, to OnStuck.
+//
+// Go offers no way to stop a goroutine, so a stuck task can't be killed; however, if ReplaceWorker is set, the worker
+// running a stuck task is abandoned (its context is cancelled, and it stops once the task returns, if ever) and is
+// replaced in its pool by a new worker, so that the pool's capacity is restored. ReplaceWorker has no effect on a
+// standalone Worker.
+type WatchdogPolicy struct {
+	Threshold     time.Duration         `config:"required"`
+	OnStuck       func(stuck StuckTask) // called on a goroutine of its own, rather than the worker's
+	ReplaceWorker bool
+}
+
+// StuckTask describes a task that has been running for longer than the watchdog's threshold (see WatchdogPolicy).
+type StuckTask struct {
+	Task     interface{}
+	WorkerID uint64
+	Elapsed  time.Duration
+	Stack    []byte // the stack of the worker goroutine, in the format of runtime.Stack; nil if unavailable
+	Replaced bool   // true if the worker was replaced (see WatchdogPolicy.ReplaceWorker)
+}
+
+func validateWatchdogPolicy(policy *WatchdogPolicy) error {
+
+	if err := config.ValidateConstraints(policy); err != nil {
+		return err
+	}
+
+	if policy.Threshold < 0 {
+		return fmt.Errorf("Threshold cannot be negative (%v)", policy.Threshold)
+	}
+
+	return nil
+}
+
+// nextWorkerID is the ID of the most recently created worker (atomic).
+var nextWorkerID uint64
+
+func newWorkerID() uint64 {
+	return atomic.AddUint64(&nextWorkerID, 1)
+}
+
+// watch arms the watchdog for a task that the worker has just started; the returned timer must be stopped once the
+// task has finished.
+func (w *Worker) watch(task interface{}, seq uint64, started time.Time) *time.Timer {
+	return time.AfterFunc(w.options.Watchdog.Threshold, func() {
+
+		w.mutex.Lock()
+		isRunning := w.taskSeq == seq && w.isBusy
+		w.mutex.Unlock()
+
+		if !isRunning {
+			return // finished just as the timer fired
+		}
+
+		stuck := StuckTask{
+			Task:     unwrapTask(task),
+			WorkerID: w.id,
+			Elapsed:  time.Since(started),
+			Stack:    goroutineStack(atomic.LoadUint64(&w.goroutine))}
+
+		if w.options.Watchdog.ReplaceWorker && w.pool != nil {
+			stuck.Replaced = w.pool.replace(w)
+		}
+
+		if w.options.Watchdog.OnStuck != nil {
+			w.options.Watchdog.OnStuck(stuck)
+		}
+	})
+}
+
+// goroutineID returns the ID of the calling goroutine, as reported in stack traces.
+func goroutineID() uint64 {
+
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	// "goroutine 123 [running]: ..."
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		if id, err := strconv.ParseUint(string(buf[:i]), 10, 64); err == nil {
+			return id
+		}
+	}
+
+	return 0
+}
+
+// goroutineStack returns the stack of the goroutine with the provided ID, or nil if it can't be found.
+func goroutineStack(id uint64) []byte {
+
+	if id == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// the stacks are separated by blank lines, and each begins with "goroutine <id> ["
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return stack
+		}
+	}
+
+	return nil
+}
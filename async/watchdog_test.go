@@ -0,0 +1,142 @@
+package async_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/bit-mancer/go-util/async"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watchdog", func() {
+
+	var tasks chan interface{}
+
+	BeforeEach(func() {
+		tasks = make(chan interface{})
+	})
+
+	handler := func(context.Context, interface{}) error { return nil }
+
+	It("validates the options", func() {
+		_, err := NewWorkerPoolWithContext(context.Background(), tasks, handler, &Options{Watchdog: &WatchdogPolicy{}})
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewWorkerPoolWithContext(context.Background(), tasks, handler, &Options{TaskTimeout: -time.Second})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("applies the task timeout to the context passed to each task", func(done Done) {
+		errs := make(chan error, 1)
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(ctx context.Context, _ interface{}) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, &Options{
+			TaskTimeout: 10 * time.Millisecond,
+			OnError:     func(_ interface{}, err error) { errs <- err }})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		tasks <- "task"
+		Eventually(errs).Should(Receive(Equal(context.DeadlineExceeded)))
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("applies the task timeout to the context passed to submitted Callables", func(done Done) {
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, handler, &Options{TaskTimeout: 10 * time.Millisecond})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		hasDeadline, err := pool.SubmitWithContext(func(ctx context.Context) (interface{}, error) {
+			_, ok := ctx.Deadline()
+			<-ctx.Done()
+			return ok, ctx.Err()
+		}).Get()
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(hasDeadline).To(BeTrue())
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("reports stuck tasks once, with the worker's ID and stack", func(done Done) {
+		stuck := make(chan StuckTask, 10)
+		release := make(chan struct{})
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(_ context.Context, task interface{}) error {
+			if task == "stuck" {
+				<-release
+			}
+			return nil
+		}, &Options{Watchdog: &WatchdogPolicy{
+			Threshold: 20 * time.Millisecond,
+			OnStuck:   func(s StuckTask) { stuck <- s }}})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		tasks <- "fast"
+		tasks <- "stuck"
+
+		var s StuckTask
+		Eventually(stuck).Should(Receive(&s))
+		Expect(s.Task).To(Equal("stuck"))
+		Expect(s.WorkerID).NotTo(BeZero())
+		Expect(s.Elapsed).To(BeNumerically(">=", 20*time.Millisecond))
+		Expect(string(s.Stack)).To(ContainSubstring("watchdog_test"))
+		Expect(s.Replaced).To(BeFalse())
+
+		Consistently(stuck, 50*time.Millisecond).ShouldNot(Receive())
+		close(release)
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+
+	It("replaces the worker running a stuck task", func(done Done) {
+		stuck := make(chan StuckTask, 10)
+		handled := make(chan interface{}, 10)
+		release := make(chan struct{})
+
+		pool, err := NewWorkerPoolWithContext(context.Background(), tasks, func(ctx context.Context, task interface{}) error {
+			if task == "stuck" {
+				<-release // ignores the context
+			}
+			handled <- task
+			return nil
+		}, &Options{Watchdog: &WatchdogPolicy{
+			Threshold:     20 * time.Millisecond,
+			OnStuck:       func(s StuckTask) { stuck <- s },
+			ReplaceWorker: true}})
+		Expect(err).To(BeNil())
+		Expect(pool.Add(1)).To(Succeed())
+
+		tasks <- "stuck"
+
+		var s StuckTask
+		Eventually(stuck).Should(Receive(&s))
+		Expect(s.Replaced).To(BeTrue())
+		Expect(pool.Size()).To(Equal(1))
+
+		tasks <- "next" // handled by the replacement
+		Eventually(handled).Should(Receive(Equal("next")))
+
+		close(release)
+		Eventually(handled).Should(Receive(Equal("stuck")))
+
+		pool.Abandon()
+		pool.Wait()
+
+		close(done)
+	}, 3) // timeout
+})
@@ -1,8 +1,10 @@
 package async
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // WorkerPool is a pool of goroutine-based Workers.
@@ -10,14 +12,29 @@ import (
 type WorkerPool struct {
 	// Worker spec:
 	tasks      chan interface{}
-	handleTask func(interface{})
+	handleTask ContextHandler
+	options    Options
 	waitGroup  *sync.WaitGroup
 
+	// root context for all workers; cancelled on Abandon
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stats   *poolStats
+	retrier *retrier      // nil if no retry policy
+	busy    int32         // number of workers currently running a task (atomic)
+	drain   chan struct{} // closed on Shutdown
+	gate    *pauseGate    // see Pause
+
 	// mutex covers everything below:
 	mutex sync.Mutex // struct will be no-copy due to the mutex
 
 	workers     []*Worker
 	isAbandoned bool
+	abandoned   *StopHandle // the workers stopped by Abandon
+	stopping    []*Worker   // workers removed from the pool that may not yet have stopped (see Inspect)
+	isShutdown  bool
+	autoscaler  *autoscaler // non-nil while autoscaling
 }
 
 // NewWorkerPool returns a WorkerPool whose workers receive work from the provided tasks channel, and perform the work
@@ -26,6 +43,29 @@ type WorkerPool struct {
 // THREAD-SAFETY: the WorkerPool is thread-safe.
 func NewWorkerPool(tasks chan interface{}, handleTask func(interface{})) (*WorkerPool, error) {
 
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	return newWorkerPool(context.Background(), tasks, adaptHandler(handleTask), nil)
+}
+
+// NewWorkerPoolWithContext is like NewWorkerPool, but takes a context-aware handler. The pool owns a root context,
+// derived from 'ctx', from which the context passed to handleTask is derived; the root context is cancelled when 'ctx'
+// is done or Abandon() is called. Tasks implementing Deadliner additionally have their deadline applied to the context.
+//
+// NewWorkerPoolWithContext will return an error if 'ctx', 'tasks' or 'handleTask' are nil. 'opts' may be nil.
+// THREAD-SAFETY: the WorkerPool is thread-safe.
+func NewWorkerPoolWithContext(ctx context.Context, tasks chan interface{}, handleTask ContextHandler, opts *Options) (*WorkerPool, error) {
+	return newWorkerPool(ctx, tasks, handleTask, opts)
+}
+
+func newWorkerPool(ctx context.Context, tasks chan interface{}, handleTask ContextHandler, opts *Options) (*WorkerPool, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("ctx cannot be nil")
+	}
+
 	if tasks == nil {
 		return nil, fmt.Errorf("tasks channel cannot be nil")
 	}
@@ -34,16 +74,32 @@ func NewWorkerPool(tasks chan interface{}, handleTask func(interface{})) (*Worke
 		return nil, fmt.Errorf("handleTask func cannot be nil")
 	}
 
-	return &WorkerPool{
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	p := &WorkerPool{
 		tasks:      tasks,
 		handleTask: handleTask,
+		options:    copyOptions(opts),
 		waitGroup:  &sync.WaitGroup{},
+		stats:      newPoolStats(),
+		drain:      make(chan struct{}),
+		gate:       newPauseGate(),
 		mutex:      sync.Mutex{},
-		workers:    make([]*Worker, 0)}, nil
+		workers:    make([]*Worker, 0)}
+
+	p.ctx, p.cancel = context.WithCancel(ctx)
+
+	if p.options.Retry != nil {
+		p.retrier = newRetrier(p.ctx, *p.options.Retry)
+	}
+
+	return p, nil
 }
 
 // Add creates, starts, and adds to the pool a number of workers equal to count.
-// An error is returned on an attempt to add to an abandoned pool.
+// An error is returned on an attempt to add to an abandoned or shut down pool.
 func (p *WorkerPool) Add(count int) error {
 
 	p.mutex.Lock()
@@ -53,10 +109,14 @@ func (p *WorkerPool) Add(count int) error {
 		return fmt.Errorf("tried to add %d workers after pool has been abandoned", count)
 	}
 
+	if p.isShutdown {
+		return fmt.Errorf("tried to add %d workers after pool has been shut down", count)
+	}
+
 	var err error
 	newWorkers := make([]*Worker, count)
 	for i := range newWorkers {
-		if newWorkers[i], err = NewWorker(p.tasks, p.handleTask, p.waitGroup); err != nil {
+		if newWorkers[i], err = newWorker(p.ctx, p.tasks, p.handleTask, p.waitGroup, &p.options, p); err != nil {
 			return fmt.Errorf("failed to start worker #%d when adding %d workers: %v", i, count, err)
 		}
 	}
@@ -66,35 +126,162 @@ func (p *WorkerPool) Add(count int) error {
 	return nil
 }
 
-// Remove abandons and removes from the pool a number of workers equal to count. Abandoned workers will complete any
-// current task they have, and may pick up another task before actually stopping (on average, half the abandoned
-// workers will run another task before stopping).
+// Remove stops and removes from the pool a number of workers equal to count. Removed workers will complete any
+// current task they have (their contexts are not cancelled), and may pick up another task before actually stopping,
+// if one arrives at the same time as the signal to stop. To wait for the removed workers to stop, see Shrink.
 // Remove returns an error if count exceeds the size of the pool (no workers will be removed in this case).
 // An error is returned on an attempt to remove from an abandoned pool.
 func (p *WorkerPool) Remove(count int) error {
+	_, err := p.Shrink(count)
+	return err
+}
+
+// Shrink is like Remove, but additionally returns a StopHandle with which to wait for the removed workers to stop.
+func (p *WorkerPool) Shrink(count int) (*StopHandle, error) {
 
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	if p.isAbandoned {
-		return fmt.Errorf("tried to remove %d workers after pool has been abandoned", count)
+		return nil, fmt.Errorf("tried to remove %d workers after pool has been abandoned", count)
 	}
 
 	length := len(p.workers)
 
 	if count > length {
-		return fmt.Errorf("tried to remove more workers (%d) than were available in pool (%d)", count, length)
+		return nil, fmt.Errorf("tried to remove more workers (%d) than were available in pool (%d)", count, length)
 	}
 
 	firstIndexToRemove := length - count
+	removed := make([]*Worker, count)
+	copy(removed, p.workers[firstIndexToRemove:])
+
 	for i := firstIndexToRemove; i < length; i++ {
-		p.workers[i].Abandon()
+		p.workers[i].stop()
 		p.workers[i] = nil
 	}
 
 	p.workers = p.workers[:firstIndexToRemove]
+	p.pruneStopping()
+	p.stopping = append(p.stopping, removed...)
 
-	return nil
+	return newStopHandle(removed), nil
+}
+
+// Submit queues a Callable on the pool's task channel and returns a Future for its result. The Callable is run by
+// one of the pool's workers in place of handleTask. Submit blocks until the Callable has been queued, or the pool has
+// been abandoned or shut down.
+// If 'task' is nil, the pool has been abandoned or shut down, or the task channel has been closed, the returned Future
+// is already complete and carries an error.
+func (p *WorkerPool) Submit(task Callable) *Future {
+
+	if task == nil {
+		return newFailedFuture(fmt.Errorf("task cannot be nil"))
+	}
+
+	return p.submit(func(context.Context) (interface{}, error) {
+		return task()
+	}, nil)
+}
+
+// SubmitWithContext is like Submit, but the Callable is passed the context of the task, as handleTask would be (see
+// NewWorkerPoolWithContext), including any TaskTimeout.
+func (p *WorkerPool) SubmitWithContext(task ContextCallable) *Future {
+	return p.submit(task, nil)
+}
+
+// submit is like SubmitWithContext, but additionally stops waiting to queue the Callable once 'cancel' is closed, in
+// which case the returned Future carries an error.
+func (p *WorkerPool) submit(task ContextCallable, cancel <-chan struct{}) *Future {
+
+	if task == nil {
+		return newFailedFuture(fmt.Errorf("task cannot be nil"))
+	}
+
+	p.mutex.Lock()
+	isAbandoned, isShutdown := p.isAbandoned, p.isShutdown
+	p.mutex.Unlock()
+
+	if isAbandoned {
+		return newFailedFuture(fmt.Errorf("tried to submit a task after pool has been abandoned"))
+	}
+
+	if isShutdown {
+		return newFailedFuture(fmt.Errorf("tried to submit a task after pool has been shut down"))
+	}
+
+	ft := &futureTask{call: task, future: newFuture(), submitted: time.Now()}
+	if !p.send(ft, cancel) {
+		return newFailedFuture(fmt.Errorf("failed to queue the submitted task"))
+	}
+
+	return ft.future
+}
+
+// send queues a task on the task channel, blocking until the task has been queued, the pool has been abandoned or shut
+// down, or 'cancel' is closed. send returns false if the task was not queued, including if the task channel has been
+// closed (a send on which would otherwise panic).
+func (p *WorkerPool) send(task interface{}, cancel <-chan struct{}) (isSent bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			isSent = false // the task channel has been closed
+		}
+	}()
+
+	select {
+	case p.tasks <- task:
+		return true
+	case <-p.ctx.Done():
+		return false
+	case <-p.drain:
+		return false
+	case <-cancel:
+		return false
+	}
+}
+
+// replace abandons a worker whose task is stuck, and adds a new worker in its place (see WatchdogPolicy). replace
+// returns false if the worker was not replaced, e.g. because it has been removed, or the pool has been shut down.
+func (p *WorkerPool) replace(w *Worker) bool {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.isAbandoned || p.isShutdown {
+		return false
+	}
+
+	for i, candidate := range p.workers {
+		if candidate == w {
+			replacement, err := newWorker(p.ctx, p.tasks, p.handleTask, p.waitGroup, &p.options, p)
+			if err != nil {
+				return false
+			}
+
+			p.workers[i] = replacement
+			p.pruneStopping()
+			p.stopping = append(p.stopping, w)
+			w.Abandon()
+			return true
+		}
+	}
+
+	return false
+}
+
+// detach removes a worker that has stopped on its own (e.g. due to a panic) from the pool.
+func (p *WorkerPool) detach(w *Worker) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, candidate := range p.workers {
+		if candidate == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			return
+		}
+	}
 }
 
 // Size returns the number of workers in the pool.
@@ -106,30 +293,42 @@ func (p *WorkerPool) Size() int {
 }
 
 // Abandon instructs all workers in the pool to stop in the near future, possibly abandoning any remaining items in the
-// worker task channel. Abandon is non-blocking and will immediately return, likely before the workers have stopped;
-// use Wait() to wait for all the workers to actually stop.
+// worker task channel, and cancels the pool's root context (and therefore the context passed to any in-flight task).
+// Abandon is non-blocking and will immediately return, likely before the workers have stopped; use Wait() to wait for
+// all the workers to actually stop, or see Halt. Abandon is idempotent.
 //
-// Note that on average, half the workers will complete one further task before actually stopping.
+// Note that a worker may complete one further task before actually stopping; the context passed to such tasks is
+// already cancelled.
 // Abandon is not typically called to stop workers; instead, simply close the task channel (which acts as a drain --
 // no further tasks will be queued, and any tasks left in the channel will be processed, then the workers will exit).
 func (p *WorkerPool) Abandon() {
+	p.Halt()
+}
+
+// Halt is like Abandon, but additionally returns a StopHandle with which to wait for just the workers that were in the
+// pool (i.e. not including those previously removed). Further calls return the same StopHandle.
+func (p *WorkerPool) Halt() *StopHandle {
 
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	if p.isAbandoned {
-		return
+		return p.abandoned
 	}
 	p.isAbandoned = true
+	p.cancel()
 
 	for _, w := range p.workers {
 		w.Abandon()
 	}
+
+	p.abandoned = newStopHandle(append([]*Worker(nil), p.workers...))
+	return p.abandoned
 }
 
 // Wait is a blocking call that waits for all workers in the pool to stop.
 // IMPORTANT: You must have closed the task channel and/or called Abandon() prior to calling Wait, otherwise a
-// deadlock will occur.
+// deadlock will occur. To wait with a deadline, see Shutdown.
 func (p *WorkerPool) Wait() {
 	// Don't need the mutex
 	p.waitGroup.Wait()
@@ -1,8 +1,10 @@
 package async_test
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	. "github.com/bit-mancer/go-util/async"
 
@@ -62,6 +64,48 @@ var _ = Describe("WorkerPool", func() {
 		})
 	})
 
+	Describe("NewWorkerPoolWithContext", func() {
+		onContextTask := func(context.Context, interface{}) error { return nil }
+
+		It("requires a non-nil context", func() {
+			newPool, err := NewWorkerPoolWithContext(nil, tasks, onContextTask, nil) // nolint
+			Expect(err).NotTo(BeNil())
+			Expect(newPool).To(BeNil())
+		})
+
+		It("requires a non-nil tasks channel", func() {
+			newPool, err := NewWorkerPoolWithContext(context.Background(), nil, onContextTask, nil)
+			Expect(err).NotTo(BeNil())
+			Expect(newPool).To(BeNil())
+		})
+
+		It("requires a non-nil taskHandler func", func() {
+			newPool, err := NewWorkerPoolWithContext(context.Background(), tasks, nil, nil)
+			Expect(err).NotTo(BeNil())
+			Expect(newPool).To(BeNil())
+		})
+
+		It("cancels the context of in-flight tasks when abandoned", func(done Done) {
+			started := make(chan struct{})
+			onTask := func(ctx context.Context, _ interface{}) error {
+				close(started)
+				<-ctx.Done()
+				return nil
+			}
+
+			ctxPool, err := NewWorkerPoolWithContext(context.Background(), tasks, onTask, nil)
+			Expect(err).To(BeNil())
+			Expect(ctxPool.Add(1)).To(BeNil())
+
+			tasks <- 1
+			<-started
+			ctxPool.Abandon()
+			ctxPool.Wait()
+
+			close(done)
+		}, 3) // timeout
+	})
+
 	Describe("Add", func() {
 		It("Adds workers to the pool", func() {
 			Expect(pool.Add(1)).To(BeNil())
@@ -118,6 +162,89 @@ var _ = Describe("WorkerPool", func() {
 		})
 	})
 
+	Describe("Shrink", func() {
+		It("returns a handle with which to wait for exactly the removed workers", func(done Done) {
+			release := make(chan struct{})
+			blockingPool, err := NewWorkerPool(tasks, func(interface{}) { <-release })
+			Expect(err).To(BeNil())
+
+			Expect(blockingPool.Add(1)).To(Succeed())
+			tasks <- 1
+			Eventually(func() int { return len(tasks) }).Should(Equal(0)) // the first worker is busy
+
+			Expect(blockingPool.Add(1)).To(Succeed())
+
+			idle, err := blockingPool.Shrink(1)
+			Expect(err).To(BeNil())
+			Expect(idle.Len()).To(Equal(1))
+			Expect(idle.Wait(context.Background())).To(Succeed())
+
+			busy, err := blockingPool.Shrink(1)
+			Expect(err).To(BeNil())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			Expect(busy.Wait(ctx)).To(Equal(context.DeadlineExceeded))
+			Consistently(busy.Done()).ShouldNot(BeClosed())
+
+			close(release)
+			Eventually(busy.Done()).Should(BeClosed())
+			Expect(blockingPool.Size()).To(Equal(0))
+
+			_, err = blockingPool.Shrink(1)
+			Expect(err).To(HaveOccurred())
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	Describe("Submit", func() {
+		It("runs the Callable in place of the task handler", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+
+			f := pool.Submit(func() (interface{}, error) {
+				return 42, nil
+			})
+
+			value, err := f.Get()
+			Expect(err).To(BeNil())
+			Expect(value).To(Equal(42))
+			Expect(atomic.LoadUint32(&callCount)).To(Equal(uint32(0)))
+
+			close(done)
+		}, 3) // timeout
+
+		It("returns a failed Future if the Callable is nil", func() {
+			_, err := pool.Submit(nil).Get()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns a failed Future if called on an abandoned pool", func() {
+			pool.Abandon()
+			_, err := pool.Submit(func() (interface{}, error) { return nil, nil }).Get()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns a failed Future if the task channel has been closed", func() {
+			close(tasks)
+			_, err := pool.Submit(func() (interface{}, error) { return nil, nil }).Get()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns a failed Future if the pool is abandoned while waiting to queue the Callable", func(done Done) {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				pool.Abandon()
+			}()
+
+			tasks <- "queued" // no workers, so the Callable cannot be queued
+			_, err := pool.Submit(func() (interface{}, error) { return nil, nil }).Get()
+			Expect(err).To(HaveOccurred())
+
+			close(done)
+		}, 3) // timeout
+	})
+
 	Describe("Size", func() {
 		It("changes based on Adds and Removes", func() {
 			Expect(pool.Size()).To(Equal(0))
@@ -145,6 +272,31 @@ var _ = Describe("WorkerPool", func() {
 
 			close(done)
 		}, 3) // timeout
+
+		It("is idempotent", func(done Done) {
+			Expect(pool.Add(1)).To(BeNil())
+			pool.Abandon()
+			pool.Abandon()
+			pool.Wait()
+
+			close(done)
+		}, 3) // timeout
+	})
+
+	Describe("Halt", func() {
+		It("is idempotent, and returns a handle for the workers in the pool", func(done Done) {
+			Expect(pool.Add(3)).To(BeNil())
+			Expect(pool.Remove(1)).To(BeNil())
+
+			handle := pool.Halt()
+			Expect(handle.Len()).To(Equal(2))
+			Expect(pool.Halt()).To(BeIdenticalTo(handle))
+			pool.Abandon() // no effect
+
+			Expect(handle.Wait(context.Background())).To(Succeed())
+
+			close(done)
+		}, 3) // timeout
 	})
 
 	// TODO need more of an integration-level test to properly vet this
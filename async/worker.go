@@ -1,20 +1,91 @@
 package async
 
 import (
+	"context"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bit-mancer/go-util/util"
 )
 
+// ContextHandler is a task handler that receives a context, which is cancelled when the worker (or its pool) is
+// abandoned, or when the task's own deadline passes (see Deadliner).
+type ContextHandler func(ctx context.Context, task interface{}) error
+
+// Deadliner is an optional interface for tasks that carry their own deadline; the context passed to a ContextHandler
+// for such a task is done once the deadline passes. A task whose deadline has already passed when a worker receives
+// it is not run, and is reported to Options.OnError instead.
+type Deadliner interface {
+	Deadline() (deadline time.Time, ok bool)
+}
+
+// envelope is implemented by the internal wrappers in which types built on a WorkerPool (e.g. the OrderedProcessor)
+// carry tasks through the pool (see carrier). The options' hooks (and the Deadliner and Limiter checks) see the wrapped
+// task.
+type envelope interface {
+	unwrap() interface{}
+}
+
+// finisher is implemented by tasks that a worker notifies, by calling their done method, once they are done (i.e. have
+// succeeded or finally failed), e.g. a DurableTask.
+type finisher interface {
+	done(err error)
+}
+
+// carrier is implemented by envelopes that carry their own handler: a worker runs a carrier by calling its handle method
+// in place of the handler.
+type carrier interface {
+	envelope
+	finisher
+	handle(ctx context.Context) error
+}
+
+// unwrapTask returns the task carried by an envelope, or the task itself.
+func unwrapTask(task interface{}) interface{} {
+	if e, ok := task.(envelope); ok {
+		return e.unwrap()
+	}
+
+	return task
+}
+
 // Worker represents a goroutine that handles abstract, structured tasks. Workers can be pooled and managed via WorkerPool.
 type Worker struct {
 	_ util.NoCopy // trigger go vet on copy
 
 	tasks      chan interface{}
-	handleTask func(interface{})
+	handleTask ContextHandler
+	options    Options
 	waitGroup  *sync.WaitGroup
-	abandon    chan struct{}
+	abandon    chan struct{} // closed to stop the worker
+	stopOnce   sync.Once
+	stopped    chan struct{} // closed once the worker goroutine has stopped
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	taskCtx context.Context // the context from which task contexts are derived; carries the per-worker value
+	value   interface{}     // the per-worker value (see Options.OnWorkerStart)
+
+	pool    *WorkerPool // the owning pool, if any
+	retrier *retrier    // nil if no retry policy; shared with the pool, if any
+	gate    *pauseGate  // nil for standalone workers; shared with the pool, if any
+
+	id        uint64
+	started   time.Time
+	goroutine uint64 // the ID of the worker goroutine, for stack traces; only set if there is a watchdog (atomic)
+
+	// mutex covers everything below:
+	mutex sync.Mutex // struct will be no-copy due to the mutex
+
+	taskSeq     uint64 // incremented as each task is started
+	isBusy      bool
+	task        interface{} // the running task (as received), if busy
+	taskStarted time.Time
+	completed   uint64
 }
 
 // NewWorker creates, starts, and returns a new Worker. The worker will accept items from the 'tasks' channel and run
@@ -25,6 +96,29 @@ type Worker struct {
 // which case an internal WaitGroup will be used (see the Wait method).
 func NewWorker(tasks chan interface{}, handleTask func(interface{}), waitGroup *sync.WaitGroup) (*Worker, error) {
 
+	if handleTask == nil {
+		return nil, fmt.Errorf("handleTask func cannot be nil")
+	}
+
+	return newWorker(context.Background(), tasks, adaptHandler(handleTask), waitGroup, nil, nil)
+}
+
+// NewWorkerWithContext is like NewWorker, but takes a context-aware handler. The context passed to handleTask is
+// derived from 'ctx', and is cancelled when 'ctx' is done or Abandon() is called. Tasks implementing Deadliner
+// additionally have their deadline applied to the context.
+//
+// NewWorkerWithContext will return an error if 'ctx', 'tasks' or 'handleTask' are nil. 'opts' may be nil.
+func NewWorkerWithContext(ctx context.Context, tasks chan interface{}, handleTask ContextHandler, waitGroup *sync.WaitGroup, opts *Options) (*Worker, error) {
+	return newWorker(ctx, tasks, handleTask, waitGroup, opts, nil)
+}
+
+// newWorker creates and starts a worker; 'pool' is the owning pool, or nil for a standalone worker.
+func newWorker(ctx context.Context, tasks chan interface{}, handleTask ContextHandler, waitGroup *sync.WaitGroup, opts *Options, pool *WorkerPool) (*Worker, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("ctx cannot be nil")
+	}
+
 	if tasks == nil {
 		return nil, fmt.Errorf("tasks channel cannot be nil")
 	}
@@ -33,6 +127,10 @@ func NewWorker(tasks chan interface{}, handleTask func(interface{}), waitGroup *
 		return nil, fmt.Errorf("handleTask func cannot be nil")
 	}
 
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
 	if waitGroup == nil {
 		waitGroup = &sync.WaitGroup{}
 	}
@@ -40,55 +138,381 @@ func NewWorker(tasks chan interface{}, handleTask func(interface{}), waitGroup *
 	w := &Worker{
 		tasks:      tasks,
 		handleTask: handleTask,
+		options:    copyOptions(opts),
 		waitGroup:  waitGroup,
-		abandon:    make(chan struct{})}
+		abandon:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+		pool:       pool,
+		id:         newWorkerID(),
+		started:    time.Now(),
+		mutex:      sync.Mutex{}}
+
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	w.taskCtx = w.ctx
+
+	if pool != nil {
+		w.retrier = pool.retrier
+		w.gate = pool.gate
+	} else if w.options.Retry != nil {
+		w.retrier = newRetrier(w.ctx, *w.options.Retry)
+	}
 
 	start(w)
 	return w, nil
 }
 
+// adaptHandler wraps a plain task handler as a ContextHandler.
+func adaptHandler(handleTask func(interface{})) ContextHandler {
+	return func(_ context.Context, task interface{}) error {
+		handleTask(task)
+		return nil
+	}
+}
+
 // Design notes: having a signaling channel for quits and using a select, rather than doing a 'for range' on just the
 // tasks channel, allows for the following:
 //	- A portion of workers can be gracefully removed (via Abandon) in designs that use a single channel spread across
 // 	  multiple workers, while the remaining workers continue to process the tasks in the channel.
 //  - Closing the channel acts as a drain (the workers will run until they have consumed all the tasks), and the drain
 // 	  can be interrupted by Abandon().
+//  - A pool shutdown (WorkerPool.Shutdown) switches the workers to draining without closing the caller-owned channel.
+//  - Retries (see RetryPolicy) arrive on a separate channel; once the task channel is closed (or drained), workers
+//    continue to run until there are no pending retries.
 func start(w *Worker) {
 
 	w.waitGroup.Add(1)
 
 	go func() {
 		defer w.waitGroup.Done()
+		defer close(w.stopped)
+		defer w.cancel() // release the context's resources
+
+		if w.options.Watchdog != nil {
+			atomic.StoreUint64(&w.goroutine, goroutineID())
+		}
 
-		for {
+		if !w.startHook() {
+			return
+		}
+		defer w.stopHook()
+
+		w.loop()
+	}()
+}
+
+func (w *Worker) loop() {
+
+	tasks := w.tasks
+
+	var drain chan struct{} // nil (never ready) for standalone workers
+	if w.pool != nil {
+		drain = w.pool.drain
+	}
+
+	var retries chan interface{} // nil (never ready) if there is no retry policy
+	if w.retrier != nil {
+		retries = w.retrier.retries
+	}
+
+	isDraining := false
+
+	for {
+		// check for the stop signal first: a select with both the signal and a task ready picks one at random
+		select {
+		case <-w.abandon:
+			return
+		default:
+		}
+
+		// hold back while the pool is paused (see WorkerPool.Pause); a pause or resume wakes the select below
+		if !w.gate.await(w.abandon) {
+			return
+		}
+		_, pauseChanged := w.gate.state()
+
+		if isDraining && tasks != nil {
 			select {
-			case task, ok := <-w.tasks:
-				if ok {
-					w.handleTask(task)
-				} else {
+			case task, ok := <-tasks:
+				if !ok {
+					tasks = nil
+				} else if !w.process(task) {
 					return
 				}
+				continue
 
 			case <-w.abandon:
 				return
+
+			default: // drained
+				tasks = nil
+			}
+		}
+
+		var retriesIdle <-chan struct{} // once there are no more tasks, wait for any pending retries
+		if tasks == nil {
+			if w.retrier == nil {
+				return
+			}
+
+			var pending int
+			if pending, retriesIdle = w.retrier.status(); pending == 0 {
+				return
+			}
+		}
+
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				tasks = nil
+			} else if !w.process(task) {
+				return
+			}
+
+		case task := <-retries:
+			if !w.process(task) {
+				return
 			}
+
+		case <-drain:
+			isDraining = true
+			drain = nil
+
+		case <-retriesIdle:
+
+		case <-pauseChanged:
+
+		case <-w.abandon:
+			return
+		}
+	}
+}
+
+// process runs a single task received from the task channel (or the retry channel), while maintaining the stats and
+// the pool's bookkeeping. process returns false if the worker should stop.
+func (w *Worker) process(received interface{}) bool {
+
+	startTime := time.Now()
+	wait := waitTime(received, startTime)
+
+	task, attempts, firstAttempt := received, 1, startTime
+	if rt, ok := received.(*retryTask); ok {
+		task, attempts, firstAttempt = rt.task, rt.attempts+1, rt.firstAttempt
+		defer w.retrier.finished() // deferred so that a rescheduled retry is counted before this one is released
+	}
+
+	// the pool may have been paused as the task was received; the held task is not dropped if the worker is stopped
+	// meanwhile: a removed worker runs it once the pool is resumed, and an abandoned worker runs it straight away, with
+	// its context cancelled (see run)
+	if !w.gate.await(w.abandon) {
+		w.gate.await(w.ctx.Done())
+	}
+
+	if w.options.StatsHook != nil {
+		w.options.StatsHook.TaskStarted(unwrapTask(task), wait)
+	}
+
+	if w.pool != nil {
+		w.pool.stats.taskStarted(wait)
+		atomic.AddInt32(&w.pool.busy, 1)
+	}
+
+	w.mutex.Lock()
+	w.taskSeq++
+	w.isBusy, w.task, w.taskStarted = true, task, startTime
+	seq := w.taskSeq
+	w.mutex.Unlock()
+
+	var watchdog *time.Timer
+	if w.options.Watchdog != nil {
+		watchdog = w.watch(task, seq, startTime)
+	}
+
+	keepRunning, err := w.runSafely(task)
+	elapsed := time.Since(startTime)
+
+	if watchdog != nil {
+		watchdog.Stop()
+	}
+
+	w.mutex.Lock()
+	w.isBusy, w.task, w.taskStarted = false, nil, time.Time{}
+	w.completed++
+	w.mutex.Unlock()
+
+	if w.pool != nil {
+		atomic.AddInt32(&w.pool.busy, -1)
+		w.pool.stats.taskFinished(elapsed, err)
+	}
+
+	if w.options.StatsHook != nil {
+		w.options.StatsHook.TaskFinished(unwrapTask(task), elapsed, err)
+	}
+
+	isRetrying := false
+	if err != nil {
+		isRetrying = w.fail(task, err, attempts, firstAttempt)
+	}
+
+	if f, ok := task.(finisher); ok && !isRetrying {
+		f.done(err)
+	}
+
+	if !keepRunning && w.pool != nil {
+		w.pool.detach(w)
+	}
+
+	return keepRunning
+}
+
+// fail either schedules a failed task for retry, or reports it as having finally failed. fail returns true if the
+// task will be retried.
+func (w *Worker) fail(task interface{}, err error, attempts int, firstAttempt time.Time) bool {
+
+	if _, ok := task.(*futureTask); ok {
+		return false // reported via the Future
+	}
+
+	if w.retrier != nil && attempts < w.retrier.policy.MaxAttempts && w.ctx.Err() == nil && w.retrier.policy.isRetryable(err) {
+		w.retrier.schedule(&retryTask{
+			task:         task,
+			attempts:     attempts,
+			firstAttempt: firstAttempt})
+		return true
+	}
+
+	task = unwrapTask(task)
+
+	if w.options.OnFailure != nil {
+		w.options.OnFailure(task, err, attempts)
+	}
+
+	if w.options.DeadLetters != nil {
+		if sinkErr := w.options.DeadLetters.Put(newDeadLetter(task, err, attempts, firstAttempt)); sinkErr != nil {
+			if w.options.OnError != nil {
+				w.options.OnError(task, fmt.Errorf("failed to store dead letter: %v", sinkErr))
+			}
+		}
+	}
+
+	return false
+}
+
+// runSafely runs a single task, recovering from any panic according to the worker's PanicPolicy. runSafely returns
+// false if the worker should stop, and the task's error (a *PanicError if the task panicked).
+func (w *Worker) runSafely(task interface{}) (keepRunning bool, err error) {
+
+	if w.options.PanicPolicy == PanicPropagate {
+		return true, w.run(task)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := &PanicError{Task: unwrapTask(task), Value: r, Stack: debug.Stack()}
+
+			if ft, ok := task.(*futureTask); ok {
+				ft.future.complete(nil, panicErr)
+			}
+
+			if w.options.OnError != nil {
+				w.options.OnError(panicErr.Task, panicErr)
+			}
+
+			err = panicErr
+			keepRunning = w.options.PanicPolicy == PanicRestart
 		}
 	}()
+
+	return true, w.run(task)
+}
+
+// run performs a single task, returning its error. Callables submitted via WorkerPool.Submit are run directly, in
+// place of handleTask (their errors are delivered via their Future, rather than Options.OnError).
+func (w *Worker) run(task interface{}) error {
+
+	visible := unwrapTask(task) // as seen by the options' hooks
+
+	ctx := w.taskCtx
+	if w.options.TaskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.options.TaskTimeout)
+		defer cancel()
+	}
+
+	isExpired := false
+	if d, ok := visible.(Deadliner); ok {
+		if deadline, ok := d.Deadline(); ok {
+			isExpired = !time.Now().Before(deadline)
+
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+	}
+
+	if w.options.Limiter != nil {
+		if err := w.options.Limiter.Wait(ctx, visible); err != nil {
+			if ft, ok := task.(*futureTask); ok {
+				ft.future.complete(nil, err)
+			} else if w.options.OnError != nil {
+				w.options.OnError(visible, err)
+			}
+			return err
+		}
+	}
+
+	if ft, ok := task.(*futureTask); ok {
+		return ft.run(ctx)
+	}
+
+	// Don't start a task whose deadline has already passed; a task received as the worker was abandoned is still run
+	// (with its context already cancelled), rather than being dropped
+	var err error
+	if isExpired {
+		err = ctx.Err()
+	} else {
+		if c, ok := task.(carrier); ok {
+			err = c.handle(ctx)
+		} else {
+			err = w.handleTask(ctx, task)
+		}
+	}
+
+	if err != nil && w.options.OnError != nil {
+		w.options.OnError(visible, err)
+	}
+
+	return err
 }
 
 // Abandon instructs the worker goroutine to stop in the near future, possibly abandoning any remaining items in
-// the worker task channel. Abandon is non-blocking and will immediately return, likely before the goroutine has
-// stopped; use the WaitGroup passed to NewWorker to wait for the goroutine to actually stop.
+// the worker task channel, and cancels the context passed to any in-flight task. Abandon is non-blocking and will
+// immediately return, likely before the goroutine has stopped; use Done (or the WaitGroup passed to NewWorker) to wait
+// for the goroutine to actually stop. Abandon is idempotent, and may be called after the worker has stopped.
 //
-// Note that an abandoned worker may complete another task before actually stopping.
+// Note that an abandoned worker may complete another task before actually stopping; the context passed to such a task
+// is already cancelled.
 //
 // Abandon is not typically called to stop workers; instead, simply close the task channel (which acts as a
 // drain -- no further tasks will be queued, any tasks left in the channel will be processed, then the worker(s)
 // will exit).
 func (w *Worker) Abandon() {
-	go func() {
-		w.abandon <- struct{}{}
-	}()
+	w.cancel()
+	w.stop()
+}
+
+// stop instructs the worker goroutine to stop in the near future without cancelling any in-flight task.
+func (w *Worker) stop() {
+	w.stopOnce.Do(func() { close(w.abandon) })
+}
+
+// ID returns the worker's ID, which is unique within the process.
+func (w *Worker) ID() uint64 {
+	return w.id
+}
+
+// Done returns a channel that is closed once the worker goroutine has stopped.
+func (w *Worker) Done() <-chan struct{} {
+	return w.stopped
 }
 
 // Wait is a blocking call that waits for the worker to stop.
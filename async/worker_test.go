@@ -1,8 +1,12 @@
 package async_test
 
 import (
+	"context"
+	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	. "github.com/bit-mancer/go-util/async"
 
@@ -10,6 +14,26 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type deadlineTask struct {
+	deadline time.Time
+}
+
+func (t deadlineTask) Deadline() (time.Time, bool) {
+	return t.deadline, true
+}
+
+// abandoningTask abandons its workers as it is received by one of them (i.e. as its wait time is measured).
+type abandoningTask struct {
+	workers []*Worker
+}
+
+func (t *abandoningTask) Timestamp() time.Time {
+	for _, worker := range t.workers {
+		worker.Abandon()
+	}
+	return time.Now()
+}
+
 var _ = Describe("Worker", func() {
 
 	Describe("NewWorker", func() {
@@ -85,6 +109,119 @@ var _ = Describe("Worker", func() {
 		})
 	})
 
+	Describe("NewWorkerWithContext", func() {
+		It("requires a context, a task channel and a handler func", func() {
+
+			tasks := make(chan interface{})
+			defer close(tasks)
+
+			onTask := func(context.Context, interface{}) error { return nil }
+
+			_, err := NewWorkerWithContext(nil, tasks, onTask, nil, nil) // nolint
+			Expect(err).NotTo(BeNil())
+
+			_, err = NewWorkerWithContext(context.Background(), nil, onTask, nil, nil)
+			Expect(err).NotTo(BeNil())
+
+			_, err = NewWorkerWithContext(context.Background(), tasks, nil, nil, nil)
+			Expect(err).NotTo(BeNil())
+
+			_, err = NewWorkerWithContext(context.Background(), tasks, onTask, nil, nil)
+			Expect(err).To(BeNil())
+		})
+
+		It("cancels the context of an in-flight task when abandoned", func(done Done) {
+
+			tasks := make(chan interface{})
+			defer close(tasks)
+
+			started := make(chan struct{})
+			onTask := func(ctx context.Context, _ interface{}) error {
+				close(started)
+				<-ctx.Done()
+				return ctx.Err()
+			}
+
+			w, err := NewWorkerWithContext(context.Background(), tasks, onTask, nil, nil)
+			Expect(err).To(BeNil())
+			tasks <- 1
+			<-started
+			w.Abandon()
+			w.Wait()
+
+			close(done)
+		})
+
+		It("cancels the context of an in-flight task when the parent context is done", func(done Done) {
+
+			tasks := make(chan interface{})
+			defer close(tasks)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			result := make(chan error, 1)
+			onTask := func(ctx context.Context, _ interface{}) error {
+				<-ctx.Done()
+				result <- ctx.Err()
+				return nil
+			}
+
+			_, err := NewWorkerWithContext(ctx, tasks, onTask, nil, nil)
+			Expect(err).To(BeNil())
+			tasks <- 1
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+
+			close(done)
+		})
+
+		It("applies the deadline of tasks implementing Deadliner", func(done Done) {
+
+			tasks := make(chan interface{})
+			defer close(tasks)
+
+			result := make(chan error, 1)
+			onTask := func(ctx context.Context, _ interface{}) error {
+				<-ctx.Done()
+				result <- ctx.Err()
+				return nil
+			}
+
+			_, err := NewWorkerWithContext(context.Background(), tasks, onTask, nil, nil)
+			Expect(err).To(BeNil())
+			tasks <- deadlineTask{time.Now().Add(10 * time.Millisecond)}
+			Expect(<-result).To(Equal(context.DeadlineExceeded))
+
+			close(done)
+		})
+
+		It("reports handler errors and expired tasks to OnError", func(done Done) {
+
+			tasks := make(chan interface{})
+			defer close(tasks)
+
+			errs := make(chan error, 2)
+			opts := &Options{
+				OnError: func(_ interface{}, err error) {
+					errs <- err
+				}}
+
+			onTask := func(context.Context, interface{}) error {
+				return fmt.Errorf("failed")
+			}
+
+			_, err := NewWorkerWithContext(context.Background(), tasks, onTask, nil, opts)
+			Expect(err).To(BeNil())
+
+			tasks <- 1
+			Expect(<-errs).To(MatchError("failed"))
+
+			tasks <- deadlineTask{time.Now().Add(-time.Second)} // already expired; not run
+			Expect(<-errs).To(Equal(context.DeadlineExceeded))
+
+			close(done)
+		})
+	})
+
 	// TODO need more of an integration-level test to properly vet this
 	It("can be waited upon to exit using a WaitGroup provided to NewWorker", func(done Done) {
 
@@ -126,6 +263,48 @@ var _ = Describe("Worker", func() {
 
 			close(done)
 		})
+
+		It("is idempotent, and doesn't leak once the worker has stopped", func(done Done) {
+			tasks := make(chan interface{})
+
+			worker, err := NewWorker(tasks, func(interface{}) {}, nil)
+			Expect(err).To(BeNil())
+
+			close(tasks)
+			Eventually(worker.Done()).Should(BeClosed())
+
+			before := runtime.NumGoroutine()
+			for i := 0; i < 100; i++ {
+				worker.Abandon()
+			}
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+
+			close(done)
+		}, 3) // timeout
+
+		It("runs a task already received from a shared channel, rather than dropping it", func(done Done) {
+			tasks := make(chan interface{})
+			handled := make(chan interface{}, 2)
+
+			onTask := func(task interface{}) {
+				handled <- task
+			}
+
+			first, err := NewWorker(tasks, onTask, nil)
+			Expect(err).To(BeNil())
+			second, err := NewWorker(tasks, onTask, nil)
+			Expect(err).To(BeNil())
+
+			task := &abandoningTask{[]*Worker{first, second}}
+			tasks <- task
+
+			Eventually(first.Done()).Should(BeClosed())
+			Eventually(second.Done()).Should(BeClosed())
+			Expect(handled).To(Receive(Equal(task)))
+			Expect(handled).NotTo(Receive())
+
+			close(done)
+		}, 3) // timeout
 	})
 
 	Describe("Wait", func() {